@@ -0,0 +1,134 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultVisibilityTimeout is the VisibilityTimeout of a SubscriberConfig
+// that sets none.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// DefaultRedeliveryBackoff is the wait before a nacked message is redelivered
+// when the RetryPolicy of the Subscriber has no Backoff.
+var DefaultRedeliveryBackoff Backoff = ExponentialBackoff{Initial: 100 * time.Millisecond, Max: DefaultVisibilityTimeout}
+
+// AckMode decides when a Subscriber is done with a message.
+type AckMode int
+
+const (
+	// AutoAck is done with a message once its handler returned. Messages
+	// are never redelivered, failed ones go to the dead-letter Topic.
+	AutoAck AckMode = iota
+	// AckOnReturn acks a message when its handler returns nil and nacks it
+	// when the handler fails, unless the handler acked or nacked it itself.
+	AckOnReturn
+	// ManualAck leaves acking to the handler, see Envelope.Ack. A failing
+	// handler still nacks the message.
+	ManualAck
+)
+
+func (m AckMode) String() string {
+	switch m {
+	case AutoAck:
+		return "AutoAck"
+	case AckOnReturn:
+		return "AckOnReturn"
+	case ManualAck:
+		return "ManualAck"
+	}
+	return fmt.Sprintf("AckMode(%d)", int(m))
+}
+
+// acker tracks a message handled by a Subscriber with an AckMode other than
+// AutoAck. The message is redelivered when it is nacked, or not acked within
+// the visibility timeout.
+type acker struct {
+	s *Subscriber
+	// redelivery is a copy of the message taken before the handler could
+	// change it.
+	redelivery *Envelope
+	settled    atomic.Bool
+	timer      *time.Timer
+}
+
+// track starts the visibility timeout of env.
+func (s *Subscriber) track(env *Envelope) *acker {
+	a := &acker{s: s, redelivery: env.clone()}
+	env.acker = a
+	timeout := s.cfg.VisibilityTimeout
+	if timeout <= 0 {
+		timeout = DefaultVisibilityTimeout
+	}
+	a.timer = time.AfterFunc(timeout, func() {
+		if a.settled.CompareAndSwap(false, true) {
+			s.log().Warn("message not acknowledged within visibility timeout", "subscriber", s.name, "topic", a.redelivery.Topic, "message_id", a.redelivery.ID)
+			s.redeliver(a.redelivery, ErrVisibilityTimeout, 0)
+		}
+	})
+	return a
+}
+
+func (a *acker) ack() (err error) {
+	if !a.settled.CompareAndSwap(false, true) {
+		return fmt.Errorf("message %s: %w", a.redelivery.ID, ErrSettled)
+	}
+	a.timer.Stop()
+	a.s.acknowledge(a.redelivery)
+	return
+}
+
+func (a *acker) nack(cause error) (err error) {
+	if !a.settled.CompareAndSwap(false, true) {
+		return fmt.Errorf("message %s: %w", a.redelivery.ID, ErrSettled)
+	}
+	a.timer.Stop()
+	backoff := a.s.cfg.Retry.Backoff
+	if backoff == nil {
+		backoff = DefaultRedeliveryBackoff
+	}
+	a.s.redeliver(a.redelivery, cause, backoff.Delay(a.redelivery.Redeliveries+1))
+	return
+}
+
+// redeliver queues env again after delay, or dead-letters it once it has
+// been delivered MaxDeliveries times.
+func (s *Subscriber) redeliver(env *Envelope, cause error, delay time.Duration) {
+	deliveries := env.Redeliveries + 1
+	if s.cfg.MaxDeliveries > 0 && deliveries >= s.cfg.MaxDeliveries {
+		err := fmt.Errorf("not acknowledged after %d deliveries: %w", deliveries, cause)
+		s.manager().deadLetter(s, DeadLetter{Envelope: env, Err: err, Attempts: deliveries, Subscriber: s.name})
+		s.acknowledge(env)
+		return
+	}
+	c := env.clone()
+	c.Redeliveries = deliveries
+	time.AfterFunc(delay, func() {
+		if err := s.deliver(context.Background(), c); err != nil {
+			s.log().Debug("cannot redeliver message", "subscriber", s.name, "topic", c.Topic, "message_id", c.ID, "error", err)
+		}
+	})
+}
+
+// Ack tells the Subscriber the message was handled. It returns an error
+// wrapping ErrSettled if the message was already acked or nacked, or is
+// being redelivered after its visibility timeout. Messages of AutoAck
+// Subscribers need no Ack, for them it does nothing.
+func (e *Envelope) Ack() error {
+	if e.acker == nil {
+		return nil
+	}
+	return e.acker.ack()
+}
+
+// Nack tells the Subscriber the message could not be handled, so it is
+// redelivered after the Backoff of its RetryPolicy, or
+// DefaultRedeliveryBackoff. See Ack for the errors.
+func (e *Envelope) Nack() error {
+	if e.acker == nil {
+		return nil
+	}
+	return e.acker.nack(ErrNacked)
+}
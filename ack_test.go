@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_AckMode(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  SubscriberConfig
+		// handle returns the error of delivery n, and may ack or nack env.
+		handle          func(env *Envelope) error
+		wantDeliveries  []int
+		wantDeadLetters int
+	}{
+		{name: "AutoAck is not redelivered", cfg: SubscriberConfig{},
+			handle:         func(env *Envelope) error { return fmt.Errorf("failed") },
+			wantDeliveries: []int{0}, wantDeadLetters: 1},
+		{name: "AckOnReturn redelivers until success", cfg: SubscriberConfig{AckMode: AckOnReturn},
+			handle: func(env *Envelope) error {
+				if env.Redeliveries < 2 {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			},
+			wantDeliveries: []int{0, 1, 2}},
+		{name: "MaxDeliveries", cfg: SubscriberConfig{AckMode: AckOnReturn, MaxDeliveries: 2},
+			handle:         func(env *Envelope) error { return fmt.Errorf("failed") },
+			wantDeliveries: []int{0, 1}, wantDeadLetters: 1},
+		{name: "Explicit Nack overrides return", cfg: SubscriberConfig{AckMode: AckOnReturn},
+			handle: func(env *Envelope) error {
+				if env.Redeliveries == 0 {
+					_ = env.Nack()
+				}
+				return nil
+			},
+			wantDeliveries: []int{0, 1}},
+		{name: "ManualAck redelivers after visibility timeout", cfg: SubscriberConfig{AckMode: ManualAck, VisibilityTimeout: 20 * time.Millisecond},
+			handle: func(env *Envelope) error {
+				if env.Redeliveries == 1 {
+					return env.Ack()
+				}
+				return nil
+			},
+			wantDeliveries: []int{0, 1}},
+		{name: "ManualAck acked later", cfg: SubscriberConfig{AckMode: ManualAck, VisibilityTimeout: time.Second},
+			handle: func(env *Envelope) error {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					_ = env.Ack()
+				}()
+				return nil
+			},
+			wantDeliveries: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			dlq, err := tm.SetDeadLetterTopic("dlq")
+			if err != nil {
+				t.Fatal(err)
+			}
+			letters := make(chan DeadLetter, 10)
+			dlqSub, _ := tm.NewSubscriber("dlq", nil, []*Topic{dlq})
+			var collect HandlerFunc = func(msg interface{}) error {
+				letters <- msg.(DeadLetter)
+				return nil
+			}
+			_ = dlqSub.AddHandler(DeadLetter{}, &collect)
+			dlqSub.Listen()
+
+			topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+			deliveries := make(chan int, 10)
+			var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+				env, _ := EnvelopeFromContext(ctx)
+				deliveries <- env.Redeliveries
+				return tt.handle(env)
+			}
+			s, _ := tm.NewSubscriberWithConfig("s", tt.cfg, nil, []*Topic{topic})
+			_ = s.AddHandlerCtx("", &h)
+			s.Listen()
+			if err = topic.Pub(tm.NewPublisher("p"), "msg"); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int
+			for len(got) < len(tt.wantDeliveries) {
+				select {
+				case n := <-deliveries:
+					got = append(got, n)
+				case <-time.After(time.Second):
+					t.Fatalf("deliveries %v, want %v", got, tt.wantDeliveries)
+				}
+			}
+			for i := 0; i < tt.wantDeadLetters; i++ {
+				select {
+				case <-letters:
+				case <-time.After(time.Second):
+					t.Fatalf("got %d dead letters, want %d", i, tt.wantDeadLetters)
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			select {
+			case n := <-deliveries:
+				t.Errorf("unexpected delivery with Redeliveries %d", n)
+			case <-letters:
+				t.Error("unexpected dead letter")
+			default:
+			}
+			if !reflect.DeepEqual(got, tt.wantDeliveries) {
+				t.Errorf("deliveries %v, want %v", got, tt.wantDeliveries)
+			}
+			_, _ = tm.Shutdown(context.Background())
+		})
+	}
+}
+
+func TestEnvelope_AckTwice(t *testing.T) {
+	tm := NewTopicManager()
+	topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+	errs := make(chan error, 1)
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		env, _ := EnvelopeFromContext(ctx)
+		if err := env.Ack(); err != nil {
+			return err
+		}
+		errs <- env.Nack()
+		return nil
+	}
+	s, _ := tm.NewSubscriberWithConfig("s", SubscriberConfig{AckMode: ManualAck}, nil, []*Topic{topic})
+	_ = s.AddHandlerCtx("", &h)
+	s.Listen()
+	defer tm.Shutdown(context.Background())
+	_ = topic.Pub(tm.NewPublisher("p"), "msg")
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrSettled) {
+			t.Errorf("Nack() after Ack() = %v, want ErrSettled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not handled")
+	}
+}
+
+func TestSubscriber_NackBackoff(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry RetryPolicy
+		// want is the most deliveries within 150ms.
+		want int
+	}{
+		{name: "DefaultRedeliveryBackoff", want: 2},
+		{name: "Retry backoff", retry: RetryPolicy{Backoff: ConstantBackoff(40 * time.Millisecond)}, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			defer tm.Shutdown(context.Background())
+			topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+			var calls atomic.Int64
+			var h HandlerFunc = func(msg interface{}) error {
+				calls.Add(1)
+				return fmt.Errorf("failed")
+			}
+			s, _ := tm.NewSubscriberWithConfig("s", SubscriberConfig{AckMode: AckOnReturn, Retry: tt.retry}, Handlers{"any": &h}, []*Topic{topic})
+			s.Listen()
+			if err := topic.Pub(tm.NewPublisher("p"), "msg"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(150 * time.Millisecond)
+			if got := int(calls.Load()); got == 0 || got > tt.want {
+				t.Errorf("%d deliveries in 150ms, want 1 to %d", got, tt.want)
+			}
+		})
+	}
+}
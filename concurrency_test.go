@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestConcurrentSubPubUnsub is meant to be run with -race.
+func TestConcurrentSubPubUnsub(t *testing.T) {
+	const (
+		topicCount      = 4
+		subscriberCount = 16
+		publisherCount  = 8
+		messageCount    = 200
+	)
+	topics := make([]*Topic, 0, topicCount)
+	for i := 0; i < topicCount; i++ {
+		topic, err := NewTopic(TopicName(fmt.Sprintf("TestConcurrentSubPubUnsub %d", i)), TopicConfig{AllowAllPublishers: true, AllowAddPub: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics = append(topics, topic)
+	}
+
+	var received atomic.Int64
+	var h HandlerFunc = func(msg interface{}) error {
+		received.Add(1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < subscriberCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, _ := NewSubscriber(fmt.Sprintf("TestConcurrentSubPubUnsub %d", i), Handlers{"any": &h}, nil)
+			s.Listen()
+			for _, topic := range topics {
+				if err := s.Sub(topic); err != nil {
+					t.Error(err)
+				}
+				_ = s.AddHandler(0, &h)
+				_ = s.GetSubscriptions()
+			}
+			for j := 0; j < 50; j++ {
+				topic := topics[j%topicCount]
+				if err := s.Unsub(topic); err != nil {
+					t.Error(err)
+				}
+				if err := s.Sub(topic); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < publisherCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := NewPublisher(fmt.Sprintf("TestConcurrentSubPubUnsub %d", i))
+			for _, topic := range topics {
+				_ = p.AddSubscription(topic)
+				_ = topic.AddPub(p)
+			}
+			for j := 0; j < messageCount; j++ {
+				if err := p.PubAll(j); err != nil {
+					t.Error(err)
+				}
+				_ = topics[j%topicCount].Subscribers()
+				_ = topics[j%topicCount].Publishers()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if received.Load() == 0 {
+		t.Errorf("no messages received")
+	}
+}
@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+// DeadLetter is published to the dead-letter Topic of the TopicManager for
+// every message a Subscriber could not handle, either because it has no
+// handler for the message or because the handler returned an error.
+type DeadLetter struct {
+	// Envelope is the Subscriber's copy of the message.
+	Envelope *Envelope
+	// Err is the error of the handler, or wraps ErrNoHandler.
+	Err error
+	// Attempts is the number of times the handler was called.
+	Attempts   int
+	Subscriber string
+}
+
+// SetDeadLetterTopic makes the Topic with name n the dead-letter Topic of the
+// TopicManager, creating it when it is not registered yet. A created Topic
+// allows all publishers, an existing one must allow the failing Subscribers to
+// publish to it, as they publish their DeadLetters under their own name.
+// Without a dead-letter Topic unhandled messages are only logged.
+func (tm *TopicManager) SetDeadLetterTopic(n TopicName) (t *Topic, err error) {
+	t, ok := tm.get(n)
+	if !ok {
+		if t, err = tm.NewTopic(n, TopicConfig{AllowAllPublishers: true}); err != nil {
+			return nil, err
+		}
+	}
+	tm.mu.Lock()
+	tm.deadLetters = t
+	tm.mu.Unlock()
+	return
+}
+
+// DeadLetterTopic returns the dead-letter Topic, or nil if none is set.
+func (tm *TopicManager) DeadLetterTopic() *Topic {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.deadLetters
+}
+
+// deadLetter publishes dl to the dead-letter Topic from its own goroutine,
+// so a slow dead-letter Subscriber does not stall s. It is not delivered to
+// s itself. Messages from the dead-letter Topic itself are only logged, so
+// they cannot loop.
+func (tm *TopicManager) deadLetter(s *Subscriber, dl DeadLetter) {
+	s.log().Error("could not handle message", "subscriber", dl.Subscriber, "topic", dl.Envelope.Topic,
+		"message_id", dl.Envelope.ID, "type", fmt.Sprintf("%T", dl.Envelope.Payload), "attempts", dl.Attempts, "error", dl.Err)
+	tm.metrics().Failed(dl.Subscriber, dl.Envelope.Topic)
+	t := tm.DeadLetterTopic()
+	if t == nil || dl.Envelope.topic == t {
+		return
+	}
+	ctx := dl.Envelope.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = context.WithValue(ctx, failedKey{}, s)
+	go func() {
+		if err := s.replier().PubCtx(ctx, t, dl); err != nil {
+			s.log().Error("could not publish dead letter", "subscriber", dl.Subscriber, "topic", t.Name(), "message_id", dl.Envelope.ID, "error", err)
+		}
+	}()
+}
+
+// failedKey holds the Subscriber that failed to handle the message of a
+// DeadLetter being published.
+type failedKey struct{}
@@ -0,0 +1,150 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_DeadLetter(t *testing.T) {
+	dlq, err := TM.SetDeadLetterTopic("TestSubscriber_DeadLetter dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = TM.DeleteTopic(dlq.Name())
+	}()
+	letters := make(chan DeadLetter, 10)
+	dlqSub, _ := NewSubscriber("TestSubscriber_DeadLetter dlq", nil, []*Topic{dlq})
+	var collect HandlerFunc = func(msg interface{}) error {
+		letters <- msg.(DeadLetter)
+		return fmt.Errorf("dead letters are not dead-lettered again")
+	}
+	_ = dlqSub.AddHandler(DeadLetter{}, &collect)
+	dlqSub.Listen()
+	defer dlqSub.Close()
+
+	topic, _ := NewTopic("TestSubscriber_DeadLetter", TopicConfig{AllowAllPublishers: true})
+	handled := make(chan string, 10)
+	var h HandlerFunc = func(msg interface{}) error {
+		if msg == "fail" {
+			return fmt.Errorf("handler failed")
+		}
+		handled <- msg.(string)
+		return nil
+	}
+	s, _ := NewSubscriber("TestSubscriber_DeadLetter", Handlers{"string": &h}, []*Topic{topic})
+	s.Listen()
+	defer s.Close()
+	p := NewPublisher("TestSubscriber_DeadLetter")
+
+	tests := []struct {
+		name         string
+		msg          interface{}
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "No handler", msg: 42, wantErr: ErrNoHandler, wantAttempts: 0},
+		{name: "Handler error", msg: "fail", wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Pub(topic, tt.msg); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case dl := <-letters:
+				if dl.Envelope.Payload != tt.msg || dl.Envelope.Topic != topic.Name() {
+					t.Errorf("DeadLetter envelope = %+v, want payload %v from %s", dl.Envelope, tt.msg, topic.Name())
+				}
+				if dl.Err == nil || (tt.wantErr != nil && !errors.Is(dl.Err, tt.wantErr)) {
+					t.Errorf("DeadLetter error = %v, want %v", dl.Err, tt.wantErr)
+				}
+				if dl.Attempts != tt.wantAttempts || dl.Subscriber != s.Name() {
+					t.Errorf("DeadLetter = %+v, want %d attempts by %s", dl, tt.wantAttempts, s.Name())
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no dead letter received")
+			}
+
+			// The listener keeps handling messages.
+			if err := p.Pub(topic, "ok"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("listener stopped after dead letter")
+			}
+		})
+	}
+
+	select {
+	case dl := <-letters:
+		t.Errorf("unexpected dead letter %+v", dl)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriber_DeadLetterPattern(t *testing.T) {
+	tm := NewTopicManager()
+	dlq, err := tm.SetDeadLetterTopic("dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, _ := tm.NewTopic("orders", TopicConfig{AllowAllPublishers: true})
+	letters := make(chan DeadLetter, 10)
+	var collect HandlerFunc = func(msg interface{}) error {
+		letters <- msg.(DeadLetter)
+		return nil
+	}
+	dlqSub, _ := tm.NewSubscriber("dlq", Handlers{TypeKey(reflect.TypeOf(DeadLetter{})): &collect}, []*Topic{dlq})
+	dlqSub.Listen()
+	defer dlqSub.Close()
+
+	// Subscribed to every Topic, also to the dead-letter Topic, with an
+	// unbuffered queue.
+	own := make(chan DeadLetter, 10)
+	var fail HandlerFunc = func(msg interface{}) error {
+		if dl, ok := msg.(DeadLetter); ok {
+			own <- dl
+			return nil
+		}
+		return fmt.Errorf("handler failed")
+	}
+	s, _ := tm.NewSubscriber("all", Handlers{"any": &fail}, nil)
+	if err = s.SubPattern(">"); err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	defer s.Close()
+
+	p := tm.NewPublisher("p")
+	done := make(chan error)
+	go func() { done <- topic.Pub(p, "a", "b") }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pub blocked on the failing subscriber")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case dl := <-letters:
+			if dl.Subscriber != "all" {
+				t.Errorf("DeadLetter by %s, want all", dl.Subscriber)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no dead letter received")
+		}
+	}
+	select {
+	case dl := <-own:
+		t.Errorf("subscriber got its own dead letter %+v", dl)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
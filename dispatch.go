@@ -0,0 +1,187 @@
+package pubsub
+
+import (
+	"context"
+	"go/token"
+	"reflect"
+)
+
+// TypeKey identifies rt by its package path and name, so same-named types of
+// different packages do not collide. Predeclared and unnamed types are
+// identified by their string representation, e.g. "int" or "[]string".
+// TypeKey is used for the keys of Types and Handlers.
+func TypeKey(rt reflect.Type) string {
+	if rt.Name() != "" && rt.PkgPath() != "" {
+		return rt.PkgPath() + "." + rt.Name()
+	}
+	return rt.String()
+}
+
+// handlerEntry is a registered HandlerFunc or HandlerCtxFunc.
+type handlerEntry struct {
+	fn    *HandlerFunc
+	ctxFn *HandlerCtxFunc
+	// retry overrides the RetryPolicy of the Subscriber when set.
+	retry *RetryPolicy
+}
+
+func (h handlerEntry) call(ctx context.Context, msg interface{}) error {
+	if h.ctxFn != nil {
+		return (*h.ctxFn)(ctx, msg)
+	}
+	return (*h.fn)(msg)
+}
+
+// handlerRegistry finds the handler for a message by its reflect.Type.
+type handlerRegistry struct {
+	types map[reflect.Type]handlerEntry
+	// named holds the handlers registered by TypeKey through Handlers.
+	named map[string]handlerEntry
+	// bare holds the TypeKey of the type each key of named without package
+	// path matched first. The key matches no other type.
+	bare map[string]string
+	// interfaces holds the interface types with a handler in registration order.
+	interfaces []reflect.Type
+	any        *handlerEntry
+}
+
+// handlerType returns the type a handler registered with typeOf handles.
+// typeOf can be "any", a reflect.Type, a pointer to an interface type such as
+// (*fmt.Stringer)(nil) for that interface type, or a value of the type.
+func handlerType(typeOf interface{}) (rt reflect.Type, isAny bool) {
+	if v, ok := typeOf.(reflect.Type); ok {
+		return v, false
+	}
+	if typeOf == "any" {
+		return nil, true
+	}
+	rt = reflect.TypeOf(typeOf)
+	if rt.Kind() == reflect.Pointer && rt.Elem().Kind() == reflect.Interface {
+		rt = rt.Elem()
+	}
+	return rt, false
+}
+
+func (r *handlerRegistry) add(typeOf interface{}, h handlerEntry) (rt reflect.Type) {
+	rt, isAny := handlerType(typeOf)
+	if isAny {
+		r.any = &h
+		return
+	}
+	if r.types == nil {
+		r.types = make(map[reflect.Type]handlerEntry)
+	}
+	if _, ok := r.types[rt]; !ok && rt.Kind() == reflect.Interface {
+		r.interfaces = append(r.interfaces, rt)
+	}
+	r.types[rt] = h
+	return
+}
+
+func (r *handlerRegistry) addNamed(key string, h handlerEntry) {
+	if key == "any" {
+		r.any = &h
+		return
+	}
+	if r.named == nil {
+		r.named = make(map[string]handlerEntry)
+	}
+	r.named[key] = h
+}
+
+// setRetry sets the RetryPolicy of the handler registered for typeOf.
+// It returns false when there is no such handler.
+func (r *handlerRegistry) setRetry(typeOf interface{}, p RetryPolicy) bool {
+	rt, isAny := handlerType(typeOf)
+	if isAny {
+		if r.any == nil {
+			return false
+		}
+		r.any.retry = &p
+		return true
+	}
+	if h, ok := r.types[rt]; ok {
+		h.retry = &p
+		r.types[rt] = h
+		return true
+	}
+	for _, key := range []string{TypeKey(rt), rt.Name()} {
+		if key == rt.Name() && r.bare[key] != "" && r.bare[key] != TypeKey(rt) {
+			continue
+		}
+		if h, ok := r.named[key]; ok && key != "" {
+			h.retry = &p
+			r.named[key] = h
+			return true
+		}
+	}
+	return false
+}
+
+// bind reports whether the key without package path n may match the type
+// with TypeKey key, which it may if it matched no other type before.
+func (r *handlerRegistry) bind(n, key string) bool {
+	if r.bare == nil {
+		r.bare = make(map[string]string)
+	}
+	if bound, ok := r.bare[n]; ok {
+		return bound == key
+	}
+	r.bare[n] = key
+	return true
+}
+
+// isBareName reports whether key may be the name of a type without its
+// package path rather than a TypeKey.
+func isBareName(key string) bool {
+	switch key {
+	case "any", "bool", "byte", "complex64", "complex128", "error", "float32", "float64",
+		"int", "int8", "int16", "int32", "int64", "rune", "string",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr":
+		return false
+	}
+	return token.IsIdentifier(key)
+}
+
+// lookup returns the handler for msg and the message to pass to it. Handlers
+// are tried in order: the exact type, the TypeKey of the type, the name of the
+// type without its package path unless it matched a type of another package
+// before, the element type
+// of a pointer or the pointer to the type, the registered interfaces the type
+// implements, and finally the "any" handler. A handler found through the
+// pointer or element type receives the message converted to that type.
+func (r *handlerRegistry) lookup(msg interface{}) (h handlerEntry, arg interface{}, ok bool) {
+	if msg != nil {
+		rt := reflect.TypeOf(msg)
+		if h, ok = r.types[rt]; ok {
+			return h, msg, true
+		}
+		if h, ok = r.named[TypeKey(rt)]; ok {
+			return h, msg, true
+		}
+		// Handlers keyed by the bare type name, as before TypeKey existed.
+		if n := rt.Name(); n != "" {
+			if h, ok = r.named[n]; ok && r.bind(n, TypeKey(rt)) {
+				return h, msg, true
+			}
+		}
+		if rt.Kind() == reflect.Pointer {
+			if h, ok = r.types[rt.Elem()]; ok && !reflect.ValueOf(msg).IsNil() {
+				return h, reflect.ValueOf(msg).Elem().Interface(), true
+			}
+		} else if h, ok = r.types[reflect.PointerTo(rt)]; ok {
+			p := reflect.New(rt)
+			p.Elem().Set(reflect.ValueOf(msg))
+			return h, p.Interface(), true
+		}
+		for _, it := range r.interfaces {
+			if rt.Implements(it) {
+				return r.types[it], msg, true
+			}
+		}
+	}
+	if r.any != nil {
+		return *r.any, msg, true
+	}
+	return handlerEntry{}, msg, false
+}
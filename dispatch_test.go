@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Duration has the same name as time.Duration.
+type Duration int64
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestTypeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{name: "Predeclared", v: 42, want: "int"},
+		{name: "Named", v: Topic{}, want: "github.com/georgegkinis/pubsub.Topic"},
+		{name: "Same name other package", v: time.Duration(0), want: "time.Duration"},
+		{name: "Same name this package", v: Duration(0), want: "github.com/georgegkinis/pubsub.Duration"},
+		{name: "Pointer", v: &Topic{}, want: "*pubsub.Topic"},
+		{name: "Slice", v: []string{}, want: "[]string"},
+		{name: "Anonymous struct", v: struct{ A int }{}, want: "struct { A int }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TypeKey(reflect.TypeOf(tt.v)); got != tt.want {
+				t.Errorf("TypeKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// legacy is registered by its bare name, as Handlers keys were before TypeKey.
+type legacy struct{}
+
+func TestHandlerRegistry_lookup(t *testing.T) {
+	handlerFor := func(name string) *HandlerFunc {
+		var h HandlerFunc = func(msg interface{}) error {
+			return errors.New(name)
+		}
+		return &h
+	}
+	var r handlerRegistry
+	r.add(0, handlerEntry{fn: handlerFor("int")})
+	r.add(Duration(0), handlerEntry{fn: handlerFor("Duration")})
+	r.add(&Topic{}, handlerEntry{fn: handlerFor("*Topic")})
+	r.add(order{}, handlerEntry{fn: handlerFor("order")})
+	r.add([]string{}, handlerEntry{fn: handlerFor("[]string")})
+	r.add(map[string]int{}, handlerEntry{fn: handlerFor("map[string]int")})
+	r.add(struct{ A int }{}, handlerEntry{fn: handlerFor("struct A")})
+	r.add((*error)(nil), handlerEntry{fn: handlerFor("error")})
+	r.add(reflect.TypeOf((*fmt.Stringer)(nil)).Elem(), handlerEntry{fn: handlerFor("Stringer")})
+	r.addNamed("bool", handlerEntry{fn: handlerFor("named bool")})
+	r.addNamed("legacy", handlerEntry{fn: handlerFor("bare name")})
+	r.addNamed("Reader", handlerEntry{fn: handlerFor("Reader")})
+
+	tests := []struct {
+		name        string
+		msg         interface{}
+		wantHandler string
+		wantArg     interface{}
+		wantOk      bool
+	}{
+		{name: "Exact type", msg: 42, wantHandler: "int", wantArg: 42, wantOk: true},
+		{name: "Same name other package", msg: time.Duration(1), wantHandler: "Stringer", wantArg: time.Duration(1), wantOk: true},
+		{name: "Same name this package", msg: Duration(1), wantHandler: "Duration", wantArg: Duration(1), wantOk: true},
+		{name: "Pointer to type with value handler", msg: &order{ID: 1}, wantHandler: "order", wantArg: order{ID: 1}, wantOk: true},
+		{name: "Value of type with pointer handler", msg: Topic{name: "t"}, wantHandler: "*Topic", wantArg: &Topic{name: "t"}, wantOk: true},
+		{name: "Slice", msg: []string{"a"}, wantHandler: "[]string", wantArg: []string{"a"}, wantOk: true},
+		{name: "Map", msg: map[string]int{"a": 1}, wantHandler: "map[string]int", wantArg: map[string]int{"a": 1}, wantOk: true},
+		{name: "Anonymous struct", msg: struct{ A int }{A: 1}, wantHandler: "struct A", wantArg: struct{ A int }{A: 1}, wantOk: true},
+		{name: "Interface error", msg: errors.New("e"), wantHandler: "error", wantOk: true},
+		{name: "Interface registered later", msg: stringer{}, wantHandler: "Stringer", wantArg: stringer{}, wantOk: true},
+		{name: "Named handler", msg: true, wantHandler: "named bool", wantArg: true, wantOk: true},
+		{name: "Named handler without package path", msg: legacy{}, wantHandler: "bare name", wantArg: legacy{}, wantOk: true},
+		{name: "Without package path first match", msg: bytes.Reader{}, wantHandler: "Reader", wantArg: bytes.Reader{}, wantOk: true},
+		{name: "Without package path other package", msg: strings.Reader{}, wantOk: false},
+		{name: "No handler", msg: 0.2, wantOk: false},
+		{name: "Nil message", msg: nil, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, arg, ok := r.lookup(tt.msg)
+			if ok != tt.wantOk {
+				t.Fatalf("lookup() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if got := h.call(nil, arg).Error(); got != tt.wantHandler {
+				t.Errorf("lookup() handler = %s, want %s", got, tt.wantHandler)
+			}
+			if tt.wantArg != nil && !reflect.DeepEqual(arg, tt.wantArg) {
+				t.Errorf("lookup() arg = %#v, want %#v", arg, tt.wantArg)
+			}
+		})
+	}
+
+	r.add("any", handlerEntry{fn: handlerFor("any")})
+	if h, _, ok := r.lookup(0.2); !ok || h.call(nil, 0.2).Error() != "any" {
+		t.Errorf("lookup() without type handler did not fall back to any")
+	}
+}
+
+func TestTypes_Allows(t *testing.T) {
+	types := NewTypes(Duration(0), []string{})
+	tests := []struct {
+		name string
+		msg  interface{}
+		want bool
+	}{
+		{name: "Registered type", msg: Duration(1), want: true},
+		{name: "Same name other package", msg: time.Duration(1), want: false},
+		{name: "Registered slice", msg: []string{}, want: true},
+		{name: "Other slice", msg: []int{}, want: false},
+		{name: "Nil", msg: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := types.Allows(tt.msg); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
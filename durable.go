@@ -0,0 +1,498 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the SegmentSize of a DurableConfig that sets none.
+const DefaultSegmentSize = 64 << 20
+
+// DefaultCommitInterval is the CommitInterval of a DurableConfig that sets none.
+const DefaultCommitInterval = 100 * time.Millisecond
+
+// FsyncPolicy decides when a durable Topic flushes its log to disk.
+type FsyncPolicy int
+
+const (
+	// FsyncAlways flushes every publish before it is delivered.
+	FsyncAlways FsyncPolicy = iota
+	// FsyncInterval flushes every FsyncInterval, losing at most the
+	// messages of one interval on a crash of the machine.
+	FsyncInterval
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever
+)
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "FsyncAlways"
+	case FsyncInterval:
+		return "FsyncInterval"
+	case FsyncNever:
+		return "FsyncNever"
+	}
+	return fmt.Sprintf("FsyncPolicy(%d)", int(p))
+}
+
+// DurableConfig makes a Topic append every published message to a
+// write-ahead log in Dir before delivering it. The log keeps the offset of
+// the first message each Subscriber has not handled, so a Subscriber with
+// the same name resumes from there when it subscribes again, also after a
+// restart. See StartPosition for other places to start from.
+//
+// Payloads are stored with encoding/gob, so their types must be registered
+// with gob.Register.
+type DurableConfig struct {
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// SegmentSize is the size in bytes after which a new segment file is
+	// started, DefaultSegmentSize by default.
+	SegmentSize int64
+	// RetentionAge and RetentionSize remove the oldest segments once they
+	// are older, or the log is larger in bytes. Zero keeps them forever.
+	// Removed messages are not replayed, even if they were not handled.
+	RetentionAge  time.Duration
+	RetentionSize int64
+	// CommitInterval is how long the offsets of the Subscribers are
+	// collected before they are written, DefaultCommitInterval by default.
+	// Messages handled during the last interval before a crash are replayed.
+	CommitInterval time.Duration
+}
+
+type startKind int
+
+const (
+	startResume startKind = iota
+	startNew
+	startBeginning
+	startOffset
+	startTime
+)
+
+// StartPosition is where in the log of a durable Topic a Subscriber starts
+// to receive messages when it subscribes. The zero value is Resume.
+type StartPosition struct {
+	kind   startKind
+	offset uint64
+	time   time.Time
+}
+
+var (
+	// Resume starts after the last message the Subscriber handled, or with
+	// new messages if it never subscribed to the Topic.
+	Resume = StartPosition{}
+	// NewMessages skips all messages published before subscribing.
+	NewMessages = StartPosition{kind: startNew}
+	// Beginning replays all messages still retained in the log.
+	Beginning = StartPosition{kind: startBeginning}
+)
+
+// AtOffset starts with the message at offset, see Envelope.Offset.
+func AtOffset(offset uint64) StartPosition {
+	return StartPosition{kind: startOffset, offset: offset}
+}
+
+// AtTime starts with the first message published at or after t.
+func AtTime(t time.Time) StartPosition {
+	return StartPosition{kind: startTime, time: t}
+}
+
+func (p StartPosition) String() string {
+	switch p.kind {
+	case startNew:
+		return "NewMessages"
+	case startBeginning:
+		return "Beginning"
+	case startOffset:
+		return fmt.Sprintf("AtOffset(%d)", p.offset)
+	case startTime:
+		return fmt.Sprintf("AtTime(%s)", p.time.Format(time.RFC3339Nano))
+	}
+	return "Resume"
+}
+
+const offsetsFile = "offsets.json"
+
+// topicLog is the write-ahead log of a durable Topic together with the
+// offsets up to which each Subscriber has handled its messages.
+type topicLog struct {
+	wal   *wal
+	topic *Topic
+	// appendMu keeps offsets tracked in the order the log assigns them, so
+	// an offset is never acknowledged before a lower one is tracked.
+	appendMu sync.Mutex
+
+	mu sync.Mutex
+	// committed is the first offset each Subscriber has not handled.
+	committed map[string]uint64
+	pending   map[string]map[uint64]struct{}
+	// dirty is set when committed has changes that are not saved yet.
+	dirty bool
+
+	saveMu    sync.Mutex
+	changed   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+func openTopicLog(t *Topic, cfg DurableConfig) (l *topicLog, err error) {
+	w, err := openWAL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l = &topicLog{
+		wal:       w,
+		topic:     t,
+		committed: make(map[string]uint64),
+		pending:   make(map[string]map[uint64]struct{}),
+		changed:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	b, err := os.ReadFile(filepath.Join(cfg.Dir, offsetsFile))
+	if err != nil && !os.IsNotExist(err) {
+		w.close()
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &l.committed); err != nil {
+			w.close()
+			return nil, fmt.Errorf("reading offsets of %s: %w", cfg.Dir, err)
+		}
+	}
+	go l.commitLoop()
+	return l, nil
+}
+
+// append writes envs to the log, sets their Offset and calls route, which
+// tracks them, before the next batch is appended.
+func (l *topicLog) append(envs []*Envelope, route func()) (err error) {
+	records := make([]*walRecord, 0, len(envs))
+	for _, env := range envs {
+		records = append(records, &walRecord{
+			ID:        env.ID,
+			Time:      env.Time,
+			Publisher: env.Publisher,
+			Key:       env.Key,
+			Headers:   env.Headers,
+			Payload:   env.Payload,
+		})
+	}
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	if err = l.wal.append(records); err != nil {
+		return
+	}
+	for i, env := range envs {
+		env.Offset = records[i].Offset
+	}
+	route()
+	return
+}
+
+// track marks the messages at offsets as not handled yet by subscriber.
+func (l *topicLog) track(subscriber string, offsets ...uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.pending[subscriber]
+	if !ok {
+		p = make(map[uint64]struct{})
+		l.pending[subscriber] = p
+	}
+	for _, off := range offsets {
+		p[off] = struct{}{}
+	}
+	if _, ok := l.committed[subscriber]; !ok && len(offsets) > 0 {
+		l.committed[subscriber] = offsets[0]
+		l.changedLocked()
+	}
+}
+
+// ack marks the message at offset as handled by subscriber and commits the
+// offset below which it has handled all messages.
+func (l *topicLog) ack(subscriber string, offset uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p := l.pending[subscriber]
+	if _, ok := p[offset]; !ok {
+		return
+	}
+	delete(p, offset)
+	next := offset + 1
+	for off := range p {
+		if off < next {
+			next = off
+		}
+	}
+	if next > l.committed[subscriber] {
+		l.committed[subscriber] = next
+		l.changedLocked()
+	}
+}
+
+// changedLocked marks the offsets for saving. l.mu must be held.
+func (l *topicLog) changedLocked() {
+	l.dirty = true
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+// commitLoop saves the offsets at most once per CommitInterval.
+func (l *topicLog) commitLoop() {
+	defer close(l.stopped)
+	interval := l.wal.cfg.CommitInterval
+	if interval <= 0 {
+		interval = DefaultCommitInterval
+	}
+	timer := time.NewTimer(interval)
+	timer.Stop()
+	for {
+		select {
+		case <-l.changed:
+			timer.Reset(interval)
+			select {
+			case <-timer.C:
+			case <-l.done:
+				timer.Stop()
+				return
+			}
+			if err := l.save(); err != nil {
+				l.topic.log().Error("cannot save offsets", "topic", l.topic.Name(), "error", err)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// save writes the committed offsets if they changed, replacing the file
+// atomically. Offsets that cannot be written are tried again next time.
+func (l *topicLog) save() (err error) {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return
+	}
+	b, err := json.Marshal(l.committed)
+	l.dirty = false
+	l.mu.Unlock()
+	if err == nil {
+		err = writeFile(filepath.Join(l.wal.cfg.Dir, offsetsFile), b, l.wal.cfg.Fsync != FsyncNever)
+	}
+	if err != nil {
+		l.mu.Lock()
+		l.changedLocked()
+		l.mu.Unlock()
+		return fmt.Errorf("saving offsets in %s: %w", l.wal.cfg.Dir, err)
+	}
+	return
+}
+
+// writeFile replaces the file at path with b through a temporary file.
+func writeFile(path string, b []byte, sync bool) (err error) {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// start moves subscriber to pos and returns the range of offsets to replay
+// to it. Messages appended from then on are delivered to it when published.
+func (l *topicLog) start(subscriber string, pos StartPosition) (from, to uint64, err error) {
+	to = l.wal.end()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	committed, known := l.committed[subscriber]
+	switch pos.kind {
+	case startResume:
+		if known {
+			return committed, to, nil
+		}
+		from = to
+	case startNew:
+		from = to
+	case startBeginning:
+		from = l.wal.first()
+	case startOffset:
+		from = pos.offset
+		if first := l.wal.first(); from < first {
+			from = first
+		}
+		if from > to {
+			from = to
+		}
+	case startTime:
+		if from, err = l.wal.offsetAt(pos.time, to); err != nil {
+			return
+		}
+	}
+	delete(l.pending, subscriber)
+	if !known || committed != from {
+		l.committed[subscriber] = from
+		l.changedLocked()
+	}
+	return
+}
+
+// offset returns the first offset subscriber has not handled.
+func (l *topicLog) offset(subscriber string) (offset uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offset, ok = l.committed[subscriber]
+	return
+}
+
+// close saves the offsets and closes the log.
+func (l *topicLog) close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		<-l.stopped
+		err = errors.Join(l.save(), l.wal.close())
+	})
+	return
+}
+
+var errReplayStopped = errors.New("replay stopped")
+
+// replay delivers to sub the messages of the log from offset from on, until
+// it has caught up with the log and gets new messages from Pub. For a member
+// of a consumer group, it stops where another member started to get new
+// messages. When the member leaves first, another member of the group gets
+// the rest of the messages.
+func (t *Topic) replay(sub *Subscriber, from, to uint64) {
+	// replaying is false once a live member took over.
+	replaying := true
+	for {
+		next := from
+		err := t.journal.wal.read(from, to, func(r walRecord) error {
+			t.mu.RLock()
+			ok := t.replaysToLocked(sub, replaying)
+			t.mu.RUnlock()
+			if !ok {
+				return errReplayStopped
+			}
+			env := &Envelope{
+				ID:        r.ID,
+				Time:      r.Time,
+				Topic:     t.Name(),
+				Publisher: r.Publisher,
+				Key:       r.Key,
+				Headers:   r.Headers,
+				Payload:   r.Payload,
+				Offset:    r.Offset,
+				ctx:       context.Background(),
+				topic:     t,
+			}
+			if env.Headers == nil {
+				env.Headers = make(map[string]string)
+			}
+			t.journal.track(sub.consumer(), r.Offset)
+			if err := sub.deliver(context.Background(), env); errors.Is(err, ErrClosed) {
+				return err
+			}
+			next = r.Offset + 1
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrClosed) && !errors.Is(err, errReplayStopped) {
+			t.log().Error("cannot replay messages", "topic", t.Name(), "subscriber", sub.Name(), "error", err)
+		}
+
+		t.mu.Lock()
+		g := t.groups[sub.cfg.Group]
+		if err == nil && t.replaysToLocked(sub, replaying) {
+			end := t.journal.wal.end()
+			if g != nil && g.liveFrom > 0 && g.liveFrom < end {
+				end = g.liveFrom
+			}
+			if end > to {
+				t.mu.Unlock()
+				from, to = to, end
+				continue
+			}
+			t.stopReplayLocked(sub, replaying)
+			if g != nil {
+				g.liveFrom = 0
+			}
+			t.mu.Unlock()
+			return
+		}
+		t.stopReplayLocked(sub, replaying)
+		heir := t.heirLocked(sub)
+		if heir == nil && g != nil {
+			g.liveFrom = 0
+		}
+		t.mu.Unlock()
+		if heir == nil {
+			// The group replays the rest when a member subscribes again.
+			return
+		}
+		t.log().Debug("member left during replay, another one continues", "topic", t.Name(), "subscriber", sub.Name(), "heir", heir.Name())
+		sub, replaying, from = heir, false, next
+	}
+}
+
+// replaysToLocked reports whether sub is still the one to replay to: a
+// Subscriber replaying, or a live member of a group finishing the replay of
+// another member. t.mu must be held.
+func (t *Topic) replaysToLocked(sub *Subscriber, replaying bool) bool {
+	if replaying {
+		return t.replaying[sub.Name()] == sub
+	}
+	return t.subscribers[sub.Name()] == sub && !sub.isClosed()
+}
+
+// stopReplayLocked ends the replay to sub. t.mu must be held.
+func (t *Topic) stopReplayLocked(sub *Subscriber, replaying bool) {
+	if replaying && t.replaying[sub.Name()] == sub {
+		delete(t.replaying, sub.Name())
+	}
+}
+
+// heirLocked returns a live member of the group of sub other than sub, or
+// nil. t.mu must be held.
+func (t *Topic) heirLocked(sub *Subscriber) (heir *Subscriber) {
+	if sub.cfg.Group == "" {
+		return nil
+	}
+	for _, s := range t.subscribers {
+		if s != sub && s.cfg.Group == sub.cfg.Group && t.replaying[s.Name()] != s && !s.isClosed() {
+			if heir == nil || s.Name() < heir.Name() {
+				heir = s
+			}
+		}
+	}
+	return
+}
+
+// acknowledge marks msg as handled by the Subscriber, so it is not replayed
+// from the log of its durable Topic.
+func (s *Subscriber) acknowledge(msg interface{}) {
+	if env, ok := msg.(*Envelope); ok && env.Offset > 0 && env.topic != nil && env.topic.journal != nil {
+		env.topic.journal.ack(s.consumer(), env.Offset)
+	}
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Envelope wraps a published message with its metadata.
+// Every Subscriber receives its own copy of the Envelope, so Headers
+// can be changed by a handler without affecting other Subscribers.
+type Envelope struct {
+	// ID is unique per published message and shared by all its copies.
+	ID        string
+	Time      time.Time
+	Topic     TopicName
+	Publisher string
+	// Key is the partition key set with WithKey. Messages with the same Key
+	// are handled in order by a KeyOrdered Subscriber.
+	Key     string
+	Headers map[string]string
+	Payload interface{}
+	// Offset is the position of the message in the log of a durable Topic,
+	// starting at 1. It is 0 for Topics that are not durable.
+	Offset uint64
+	// Redeliveries is the number of times the message was delivered to the
+	// Subscriber before, see SubscriberConfig.AckMode.
+	Redeliveries int
+
+	ctx   context.Context
+	topic *Topic
+	acker *acker
+}
+
+// newEnvelope wraps msg published with ctx. The handlers get the values of
+// ctx but not its cancellation. The headers and key set with WithHeaders and
+// WithKey end up in the Envelope only, so they do not leak into messages the
+// handlers publish.
+func newEnvelope(ctx context.Context, topic *Topic, pub *Publisher, msg interface{}) *Envelope {
+	env := &Envelope{
+		ID:      newID(),
+		Time:    time.Now(),
+		Topic:   topic.Name(),
+		Headers: make(map[string]string),
+		Payload: msg,
+		ctx:     withoutPublishValues(ctx),
+		topic:   topic,
+	}
+	if pub != nil {
+		env.Publisher = pub.Name()
+	}
+	if key, ok := ctx.Value(partitionKey{}).(string); ok {
+		env.Key = key
+	}
+	if headers, ok := ctx.Value(headersKey{}).(map[string]string); ok {
+		for k, v := range headers {
+			env.Headers[k] = v
+		}
+	}
+	return env
+}
+
+// withoutPublishValues returns ctx without its cancellation and the values
+// that only apply to the publish with ctx.
+func withoutPublishValues(ctx context.Context) context.Context {
+	ctx = context.WithValue(context.WithoutCancel(ctx), headersKey{}, nil)
+	ctx = context.WithValue(ctx, partitionKey{}, nil)
+	return context.WithValue(ctx, failedKey{}, nil)
+}
+
+// clone returns a copy of the Envelope with its own Headers.
+func (e *Envelope) clone() *Envelope {
+	c := *e
+	c.Headers = make(map[string]string, len(e.Headers))
+	for k, v := range e.Headers {
+		c.Headers[k] = v
+	}
+	return &c
+}
+
+type envelopeKey struct{}
+
+type headersKey struct{}
+
+type partitionKey struct{}
+
+// EnvelopeFromContext returns the Envelope of the message passed to a HandlerCtxFunc.
+func EnvelopeFromContext(ctx context.Context) (env *Envelope, ok bool) {
+	env, ok = ctx.Value(envelopeKey{}).(*Envelope)
+	return
+}
+
+// WithHeaders returns a context that adds headers to the Envelope of every
+// message published with it. Headers already in ctx are kept unless overwritten.
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	merged := make(map[string]string)
+	if old, ok := ctx.Value(headersKey{}).(map[string]string); ok {
+		for k, v := range old {
+			merged[k] = v
+		}
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// WithKey returns a context that sets the partition key of every message
+// published with it, see Envelope.Key.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, partitionKey{}, key)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
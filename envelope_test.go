@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeFromContext(t *testing.T) {
+	topic, _ := NewTopic("TestEnvelopeFromContext", TopicConfig{AllowAllPublishers: true})
+	received := make(chan *Envelope, 2)
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		env, ok := EnvelopeFromContext(ctx)
+		if !ok {
+			t.Errorf("EnvelopeFromContext() ok = false")
+		}
+		env.Headers["handled"] = "true"
+		received <- env
+		return nil
+	}
+	for _, name := range []string{"s1", "s2"} {
+		s, _ := NewSubscriber(name, nil, []*Topic{topic})
+		_ = s.AddHandlerCtx("any", &h)
+		s.Listen()
+	}
+
+	before := time.Now()
+	ctx := WithHeaders(WithHeaders(context.Background(), map[string]string{"a": "1", "b": "1"}), map[string]string{"b": "2"})
+	ctx = WithKey(ctx, "key")
+	if err := p1.PubCtx(ctx, topic, "payload"); err != nil {
+		t.Fatal(err)
+	}
+
+	var envs []*Envelope
+	for i := 0; i < 2; i++ {
+		select {
+		case env := <-received:
+			envs = append(envs, env)
+		case <-time.After(time.Second):
+			t.Fatal("handler not called")
+		}
+	}
+	for _, env := range envs {
+		if env.ID == "" || env.ID != envs[0].ID {
+			t.Errorf("ID = %q, want the same non-empty ID for all subscribers", env.ID)
+		}
+		if env.Topic != topic.Name() || env.Publisher != p1.Name() || env.Payload != "payload" {
+			t.Errorf("Envelope = %+v", env)
+		}
+		if env.Key != "key" {
+			t.Errorf("Key = %q, want key", env.Key)
+		}
+		if _, ok := env.ctx.Value(partitionKey{}).(string); ok {
+			t.Errorf("key leaks into the handler context")
+		}
+		if env.Time.Before(before) {
+			t.Errorf("Time = %v, want after %v", env.Time, before)
+		}
+		if env.Headers["a"] != "1" || env.Headers["b"] != "2" {
+			t.Errorf("Headers = %v, want a=1 b=2", env.Headers)
+		}
+	}
+	if reflect.ValueOf(envs[0].Headers).Pointer() == reflect.ValueOf(envs[1].Headers).Pointer() {
+		t.Errorf("Headers are shared between subscribers")
+	}
+}
+
+func TestEnvelopeFromContext_NoEnvelope(t *testing.T) {
+	if _, ok := EnvelopeFromContext(context.Background()); ok {
+		t.Errorf("EnvelopeFromContext() ok = true for context without Envelope")
+	}
+}
@@ -0,0 +1,26 @@
+package pubsub
+
+import "errors"
+
+// ErrClosed is returned when publishing to a Topic or delivering to a
+// Subscriber that has been shut down.
+var ErrClosed = errors.New("closed")
+
+// ErrQueueFull is returned to the publisher when a Subscriber with the
+// ErrorOnFull OverflowPolicy has no room in its queue.
+var ErrQueueFull = errors.New("queue full")
+
+// ErrNoHandler is the error of a DeadLetter for a message that a Subscriber
+// has no handler for, neither for its type nor for "any".
+var ErrNoHandler = errors.New("no handler")
+
+// ErrSettled is returned when acking or nacking a message that was already
+// acked, nacked or redelivered.
+var ErrSettled = errors.New("already settled")
+
+// ErrNacked is the cause of redelivering a message nacked by its handler.
+var ErrNacked = errors.New("nacked")
+
+// ErrVisibilityTimeout is the cause of redelivering a message that was not
+// acked within the VisibilityTimeout of its Subscriber.
+var ErrVisibilityTimeout = errors.New("visibility timeout")
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ExpvarMetrics publishes Metrics as expvar maps named prefix followed by
+// the metric, e.g. "pubsub_delivered". Per-subscriber metrics map the
+// subscriber to a map of its topics. The maps are served with the other
+// expvar variables on /debug/vars. NewExpvarMetrics with a prefix used
+// before reuses its maps.
+type ExpvarMetrics struct {
+	mu sync.Mutex
+
+	published             *expvar.Map
+	publishBlockedSeconds *expvar.Map
+	delivered             *expvar.Map
+	dropped               *expvar.Map
+	queueDepth            *expvar.Map
+	handled               *expvar.Map
+	handlerErrors         *expvar.Map
+	handlerSeconds        *expvar.Map
+	failed                *expvar.Map
+}
+
+// NewExpvarMetrics returns ExpvarMetrics publishing maps named with prefix,
+// "pubsub_" when prefix is empty.
+func NewExpvarMetrics(prefix string) *ExpvarMetrics {
+	if prefix == "" {
+		prefix = "pubsub_"
+	}
+	return &ExpvarMetrics{
+		published:             expvarMap(prefix + "published"),
+		publishBlockedSeconds: expvarMap(prefix + "publish_blocked_seconds"),
+		delivered:             expvarMap(prefix + "delivered"),
+		dropped:               expvarMap(prefix + "dropped"),
+		queueDepth:            expvarMap(prefix + "queue_depth"),
+		handled:               expvarMap(prefix + "handled"),
+		handlerErrors:         expvarMap(prefix + "handler_errors"),
+		handlerSeconds:        expvarMap(prefix + "handler_seconds"),
+		failed:                expvarMap(prefix + "failed"),
+	}
+}
+
+// expvarMap returns the published expvar.Map with name, publishing a new one
+// if there is none.
+func expvarMap(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
+}
+
+func (e *ExpvarMetrics) Published(topic TopicName, n int) {
+	e.published.Add(string(topic), int64(n))
+}
+
+func (e *ExpvarMetrics) PublishBlocked(topic TopicName, d time.Duration) {
+	e.publishBlockedSeconds.AddFloat(string(topic), d.Seconds())
+}
+
+func (e *ExpvarMetrics) Delivered(subscriber string, topic TopicName) {
+	e.nested(e.delivered, subscriber).Add(string(topic), 1)
+}
+
+func (e *ExpvarMetrics) Dropped(subscriber string, topic TopicName) {
+	e.nested(e.dropped, subscriber).Add(string(topic), 1)
+}
+
+func (e *ExpvarMetrics) QueueDepth(subscriber string, depth int) {
+	v := new(expvar.Int)
+	v.Set(int64(depth))
+	e.queueDepth.Set(subscriber, v)
+}
+
+func (e *ExpvarMetrics) Handled(subscriber string, topic TopicName, d time.Duration, err error) {
+	e.nested(e.handled, subscriber).Add(string(topic), 1)
+	e.nested(e.handlerSeconds, subscriber).AddFloat(string(topic), d.Seconds())
+	if err != nil {
+		e.nested(e.handlerErrors, subscriber).Add(string(topic), 1)
+	}
+}
+
+func (e *ExpvarMetrics) Failed(subscriber string, topic TopicName) {
+	e.nested(e.failed, subscriber).Add(string(topic), 1)
+}
+
+// nested returns the map of m under key, creating it if needed.
+func (e *ExpvarMetrics) nested(m *expvar.Map, key string) *expvar.Map {
+	if v, ok := m.Get(key).(*expvar.Map); ok {
+		return v
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if v, ok := m.Get(key).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map)
+	m.Set(key, v)
+	return v
+}
@@ -1,7 +1,10 @@
 module github.com/georgegkinis/pubsub
 
-go 1.20
+go 1.21
 
 require github.com/sirupsen/logrus v1.9.0
 
-require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+require (
+	github.com/stretchr/testify v1.9.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
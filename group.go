@@ -0,0 +1,198 @@
+package pubsub
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+)
+
+// GroupStrategy decides which member of a consumer group gets a message.
+type GroupStrategy int
+
+const (
+	// RoundRobin gives the messages to the members in turn.
+	RoundRobin GroupStrategy = iota
+	// LeastLoaded gives a message to the member with the fewest messages
+	// queued or being handled.
+	LeastLoaded
+	// KeyHash gives all messages with the same Envelope.Key to the same
+	// member, as long as the members do not change. Messages without a key
+	// are given round-robin.
+	KeyHash
+)
+
+func (g GroupStrategy) String() string {
+	switch g {
+	case RoundRobin:
+		return "RoundRobin"
+	case LeastLoaded:
+		return "LeastLoaded"
+	case KeyHash:
+		return "KeyHash"
+	}
+	return fmt.Sprintf("GroupStrategy(%d)", int(g))
+}
+
+// consumerGroup is the state of a consumer group on a Topic.
+type consumerGroup struct {
+	strategy GroupStrategy
+	next     atomic.Uint64
+	// liveFrom is the offset from which a durable Topic delivers to the live
+	// members while another member is replaying the log, or 0.
+	liveFrom uint64
+}
+
+// pick returns the member of the group that gets env. load holds the number
+// of messages already given to each member in this publish.
+func (g *consumerGroup) pick(members []*Subscriber, env *Envelope, load map[*Subscriber]int) *Subscriber {
+	switch {
+	case g.strategy == LeastLoaded:
+		// Ties go round-robin, so idle members share the messages.
+		start := g.next.Add(1) - 1
+		best, min := members[0], -1
+		for i := range members {
+			s := members[(start+uint64(i))%uint64(len(members))]
+			if l := s.load() + load[s]; min < 0 || l < min {
+				best, min = s, l
+			}
+		}
+		return best
+	case g.strategy == KeyHash && env.Key != "":
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(env.Key))
+		return members[h.Sum32()%uint32(len(members))]
+	}
+	return members[(g.next.Add(1)-1)%uint64(len(members))]
+}
+
+// delivery is a batch of messages for one Subscriber.
+type delivery struct {
+	s    *Subscriber
+	envs []*Envelope
+}
+
+// route decides which subscribers get which of envs: every Subscriber without
+// a group gets all of them, every group one member per message. Subscribers
+// replaying the log get nothing, they read the messages from the log, and
+// neither does skip, the Subscriber that failed to handle the message of a
+// DeadLetter. It is called with t.mu held.
+func (t *Topic) route(envs []*Envelope, skip *Subscriber) (ds []delivery) {
+	var offsets []uint64
+	if t.journal != nil {
+		offsets = make([]uint64, 0, len(envs))
+		for _, env := range envs {
+			offsets = append(offsets, env.Offset)
+		}
+	}
+	groups := make(map[string][]*Subscriber)
+	for _, s := range t.subscribers {
+		if s == skip {
+			continue
+		}
+		live := t.replaying[s.Name()] != s
+		if g := s.cfg.Group; g != "" {
+			if live {
+				groups[g] = append(groups[g], s)
+			} else if _, ok := groups[g]; !ok {
+				groups[g] = nil
+			}
+			continue
+		}
+		if t.journal != nil {
+			t.journal.track(s.Name(), offsets...)
+		}
+		if live {
+			ds = append(ds, delivery{s: s, envs: envs})
+		}
+	}
+	for g, members := range groups {
+		if t.journal != nil {
+			t.journal.track(groupConsumer(g), offsets...)
+		}
+		if len(members) == 0 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].Name() < members[j].Name() })
+		load := make(map[*Subscriber]int, len(members))
+		assigned := make(map[*Subscriber][]*Envelope, len(members))
+		for _, env := range envs {
+			s := t.groups[g].pick(members, env, load)
+			load[s]++
+			assigned[s] = append(assigned[s], env)
+		}
+		for _, s := range members {
+			if len(assigned[s]) > 0 {
+				ds = append(ds, delivery{s: s, envs: assigned[s]})
+			}
+		}
+	}
+	return
+}
+
+// receivers returns the number of copies of a message Pub delivers: one per
+// Subscriber without a group and one per group.
+func (t *Topic) receivers() (n int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	groups := make(map[string]bool)
+	for _, s := range t.subscribers {
+		if s.cfg.Group == "" {
+			n++
+		} else if !groups[s.cfg.Group] {
+			groups[s.cfg.Group] = true
+			n++
+		}
+	}
+	return
+}
+
+// joinGroup adds the group of sub to the Topic, or checks that sub uses the
+// strategy of its other members. It reports whether sub is the only member.
+// It is called with t.mu held.
+func (t *Topic) joinGroup(sub *Subscriber) (first bool, err error) {
+	name := sub.cfg.Group
+	first = true
+	for _, s := range t.subscribers {
+		if s.cfg.Group == name && s.Name() != sub.Name() {
+			first = false
+			break
+		}
+	}
+	g, ok := t.groups[name]
+	if ok && !first && g.strategy != sub.cfg.GroupStrategy {
+		return false, fmt.Errorf("subscriber %s joins group %s of topic %s with strategy %s, but the group uses %s", sub.Name(), name, t.name, sub.cfg.GroupStrategy, g.strategy)
+	}
+	if !ok || first {
+		if t.groups == nil {
+			t.groups = make(map[string]*consumerGroup)
+		}
+		t.groups[name] = &consumerGroup{strategy: sub.cfg.GroupStrategy}
+		return
+	}
+	// The first live member: the replaying one stops where sub starts.
+	if t.journal != nil && g.liveFrom == 0 {
+		for _, s := range t.subscribers {
+			if s.cfg.Group == name && t.replaying[s.Name()] == s {
+				g.liveFrom = t.journal.wal.end()
+				break
+			}
+		}
+	}
+	return
+}
+
+// groupConsumer is the name the log of a durable Topic keeps the offset of
+// group under.
+func groupConsumer(group string) string {
+	return "group:" + group
+}
+
+// consumer is the name the log of a durable Topic keeps the offset of the
+// Subscriber under: its own name, or that of its group.
+func (s *Subscriber) consumer() string {
+	if s.cfg.Group != "" {
+		return groupConsumer(s.cfg.Group)
+	}
+	return s.name
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTopic_PubGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy GroupStrategy
+		prefill  map[string]int
+		keys     []string
+		want     map[string]int
+		// wantKeyed checks that all messages with the same key went to the
+		// same member instead of checking want.
+		wantKeyed bool
+	}{
+		{name: "RoundRobin", strategy: RoundRobin,
+			keys: []string{"", "", "", "", "", ""}, want: map[string]int{"a": 2, "b": 2, "c": 2}},
+		{name: "LeastLoaded", strategy: LeastLoaded, prefill: map[string]int{"a": 4},
+			keys: []string{"", "", "", "", "", ""}, want: map[string]int{"a": 4, "b": 3, "c": 3}},
+		{name: "KeyHash", strategy: KeyHash,
+			keys: []string{"k1", "k2", "k3", "k1", "k2", "k3"}, wantKeyed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			defer tm.Shutdown(context.Background())
+			topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+			members := make(map[string]*Subscriber)
+			for _, n := range []string{"a", "b", "c"} {
+				s, err := tm.NewSubscriberWithConfig(n, SubscriberConfig{QueueSize: 20, Group: "g", GroupStrategy: tt.strategy}, nil, []*Topic{topic})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < tt.prefill[n]; i++ {
+					s.Channel() <- i
+				}
+				members[n] = s
+			}
+			plain, _ := tm.NewSubscriberWithConfig("plain", SubscriberConfig{QueueSize: 20}, nil, []*Topic{topic})
+			other, _ := tm.NewSubscriberWithConfig("other", SubscriberConfig{QueueSize: 20, Group: "h"}, nil, []*Topic{topic})
+
+			p := tm.NewPublisher("p")
+			for i, key := range tt.keys {
+				if err := topic.PubCtx(WithKey(context.Background(), key), p, i); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, s := range []*Subscriber{plain, other} {
+				if got := s.QueueLen(); got != len(tt.keys) {
+					t.Errorf("%s got %d messages, want %d", s.Name(), got, len(tt.keys))
+				}
+			}
+			got := make(map[string]int)
+			owner := make(map[string]string)
+			keyed := true
+			for n, s := range members {
+				got[n] = s.QueueLen()
+				for s.QueueLen() > 0 {
+					env, ok := (<-s.Channel()).(*Envelope)
+					if !ok {
+						continue
+					}
+					if o, seen := owner[env.Key]; seen && o != n {
+						keyed = false
+					}
+					owner[env.Key] = n
+				}
+			}
+			if tt.wantKeyed {
+				if !keyed {
+					t.Errorf("messages with the same key went to different members: %v", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("members got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopic_AddSubGroupStrategyMismatch(t *testing.T) {
+	tm := NewTopicManager()
+	topic, _ := tm.NewTopic("topic", TopicConfig{})
+	if _, err := tm.NewSubscriberWithConfig("a", SubscriberConfig{Group: "g"}, nil, []*Topic{topic}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tm.NewSubscriberWithConfig("b", SubscriberConfig{Group: "g", GroupStrategy: KeyHash}, nil, []*Topic{topic}); err == nil {
+		t.Error("joining a group with another strategy succeeded")
+	}
+}
+
+func TestTopic_PubGroupLeastLoadedBusy(t *testing.T) {
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+	busy, release := make(chan struct{}, 1), make(chan struct{})
+	handled := make(chan string, 10)
+	for _, n := range []string{"a", "b", "c"} {
+		n := n
+		var h HandlerFunc = func(msg interface{}) error {
+			if n == "a" {
+				select {
+				case busy <- struct{}{}:
+				default:
+				}
+				<-release
+			}
+			handled <- n
+			return nil
+		}
+		// Unbuffered queues, so only the handler at work shows the load.
+		s, err := tm.NewSubscriberWithConfig(n, SubscriberConfig{Group: "g", GroupStrategy: LeastLoaded}, Handlers{"any": &h}, []*Topic{topic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Listen()
+	}
+	p := tm.NewPublisher("p")
+	pub := func(i int) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- topic.Pub(p, i) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			close(release)
+			t.Fatal("message given to the busy member")
+		}
+	}
+	pub(0)
+	<-busy
+	for i := 1; i < 10; i++ {
+		pub(i)
+		if n := <-handled; n == "a" {
+			t.Fatalf("message %d handled by the busy member", i)
+		}
+	}
+	close(release)
+	if n := <-handled; n != "a" {
+		t.Errorf("first message handled by %s, want a", n)
+	}
+}
@@ -0,0 +1,51 @@
+package pubsub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// heldDirs are the log directories opened in this process.
+var heldDirs = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// dirLock keeps a log directory from being opened by another wal, in this
+// process through heldDirs and in others through a lock on its LOCK file.
+type dirLock struct {
+	dir  string
+	file *os.File
+}
+
+func lockDir(dir string) (l *dirLock, err error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	heldDirs.Lock()
+	defer heldDirs.Unlock()
+	if heldDirs.dirs[abs] {
+		return nil, fmt.Errorf("log directory %s is used by another topic", dir)
+	}
+	f, err := os.OpenFile(filepath.Join(abs, "LOCK"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("log directory %s is used by another process: %w", dir, err)
+	}
+	heldDirs.dirs[abs] = true
+	return &dirLock{dir: abs, file: f}, nil
+}
+
+// release unlocks the directory. Closing the file drops its lock.
+func (l *dirLock) release() error {
+	heldDirs.Lock()
+	defer heldDirs.Unlock()
+	delete(heldDirs.dirs, l.dir)
+	return l.file.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package pubsub
+
+import "os"
+
+// lockFile does nothing where flock is not available, only heldDirs guards
+// the directory there.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receives the log records of the package. keysAndValues alternate
+// between a key and its value, as with log/slog. The keys used are "topic",
+// "subscriber", "publisher", "type", "message_id" and "error".
+// The Logger of a Subscriber or Topic is set in its config, falling back to
+// the Logger of the TopicManager, which defaults to NopLogger.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NopLogger discards all log records.
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...interface{}) {}
+func (NopLogger) Info(string, ...interface{})  {}
+func (NopLogger) Warn(string, ...interface{})  {}
+func (NopLogger) Error(string, ...interface{}) {}
+
+// NewSlogLogger returns a Logger writing to l, or to slog.Default() when l is nil.
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
+
+// NewLogrusLogger returns a Logger writing to l with the keys and values as
+// fields, or to the standard logrus logger when l is nil.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	if l == nil {
+		l = logrus.StandardLogger()
+	}
+	return logrusLogger{l: l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (l logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.l.WithFields(fields(keysAndValues)).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.l.WithFields(fields(keysAndValues)).Info(msg)
+}
+
+func (l logrusLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.l.WithFields(fields(keysAndValues)).Warn(msg)
+}
+
+func (l logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.l.WithFields(fields(keysAndValues)).Error(msg)
+}
+
+// fields turns keysAndValues into logrus.Fields. A value without a key is
+// logged under "!BADKEY", like log/slog does.
+func fields(keysAndValues []interface{}) logrus.Fields {
+	f := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			f["!BADKEY"] = keysAndValues[i]
+			break
+		}
+		f[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	return f
+}
+
+// SetLogger sets the Logger of the TopicManager. nil restores NopLogger.
+func (tm *TopicManager) SetLogger(l Logger) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.logger = l
+}
+
+func (tm *TopicManager) log() Logger {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.logger == nil {
+		return NopLogger{}
+	}
+	return tm.logger
+}
+
+func (t *Topic) log() Logger {
+	if t.cfg.Logger != nil {
+		return t.cfg.Logger
+	}
+	return t.manager().log()
+}
+
+func (s *Subscriber) log() Logger {
+	if s.cfg.Logger != nil {
+		return s.cfg.Logger
+	}
+	return s.manager().log()
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordLogger records the messages and fields logged at Error level.
+type recordLogger struct {
+	NopLogger
+	mu      sync.Mutex
+	records []map[string]interface{}
+}
+
+func (l *recordLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := map[string]interface{}(fields(keysAndValues))
+	r["msg"] = msg
+	l.records = append(l.records, r)
+}
+
+func (l *recordLogger) Records() []map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]map[string]interface{}(nil), l.records...)
+}
+
+func TestSubscriber_Logger(t *testing.T) {
+	managerLogger := &recordLogger{}
+	TM.SetLogger(managerLogger)
+	defer TM.SetLogger(nil)
+
+	topic, _ := NewTopic("TestSubscriber_Logger", TopicConfig{AllowAllPublishers: true})
+	subLogger := &recordLogger{}
+	var h HandlerFunc = func(msg interface{}) error {
+		return fmt.Errorf("failed")
+	}
+	tests := []struct {
+		name   string
+		cfg    SubscriberConfig
+		logger *recordLogger
+	}{
+		{name: "Manager logger", cfg: SubscriberConfig{}, logger: managerLogger},
+		{name: "Subscriber logger", cfg: SubscriberConfig{Logger: subLogger}, logger: subLogger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := NewSubscriberWithConfig(tt.name, tt.cfg, Handlers{"any": &h}, []*Topic{topic})
+			s.Listen()
+			_ = topic.Pub(p1, 1)
+			_ = s.Close()
+			records := tt.logger.Records()
+			if len(records) != 1 {
+				t.Fatalf("logged %d errors, want 1", len(records))
+			}
+			r := records[0]
+			if r["subscriber"] != tt.name || r["topic"] != topic.Name() || r["type"] != "int" || r["message_id"] == "" {
+				t.Errorf("logged %v, want subscriber, topic, type and message_id", r)
+			}
+			managerLogger.mu.Lock()
+			managerLogger.records = nil
+			managerLogger.mu.Unlock()
+		})
+	}
+}
+
+func TestNewSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	l.Error("could not handle message", "topic", TopicName("t"), "attempts", 2)
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["msg"] != "could not handle message" || got["topic"] != "t" || got["attempts"] != 2.0 {
+		t.Errorf("logged %v", got)
+	}
+	if NewSlogLogger(nil) != slog.Default() {
+		t.Errorf("NewSlogLogger(nil) is not slog.Default()")
+	}
+}
+
+func TestNewLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	lr := logrus.New()
+	lr.Out = &buf
+	lr.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+	l := NewLogrusLogger(lr)
+	tests := []struct {
+		name          string
+		keysAndValues []interface{}
+		want          map[string]interface{}
+	}{
+		{name: "Fields", keysAndValues: []interface{}{"topic", "t", "subscriber", "s"},
+			want: map[string]interface{}{"level": "error", "msg": "m", "topic": "t", "subscriber": "s"}},
+		{name: "Value without key", keysAndValues: []interface{}{"topic", "t", "v"},
+			want: map[string]interface{}{"level": "error", "msg": "m", "topic": "t", "!BADKEY": "v"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			l.Error("m", tt.keysAndValues...)
+			var got map[string]interface{}
+			if err := json.NewDecoder(strings.NewReader(buf.String())).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("logged %v, want %v", got, tt.want)
+			}
+		})
+	}
+	buf.Reset()
+	l.Debug("m")
+	if buf.Len() != 0 {
+		t.Errorf("logged %q below the logrus level", buf.String())
+	}
+}
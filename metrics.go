@@ -0,0 +1,65 @@
+package pubsub
+
+import (
+	"time"
+)
+
+// Metrics receives the measurements of the package. The Metrics of the
+// TopicManager default to NopMetrics, see PrometheusMetrics and
+// ExpvarMetrics for adapters. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Published is called with the number of messages a Topic accepted
+	// for delivery in one publish.
+	Published(topic TopicName, n int)
+	// PublishBlocked is called with the time a publish spent delivering
+	// the messages to the subscriber queues.
+	PublishBlocked(topic TopicName, d time.Duration)
+	// Delivered is called for every message queued for a Subscriber.
+	Delivered(subscriber string, topic TopicName)
+	// Dropped is called for every message a Subscriber dropped without
+	// handling it, because its queue was full or it was shut down.
+	Dropped(subscriber string, topic TopicName)
+	// QueueDepth is called with the number of messages queued for a
+	// Subscriber whenever it changes.
+	QueueDepth(subscriber string, depth int)
+	// Handled is called after every call of a handler with its duration
+	// and error.
+	Handled(subscriber string, topic TopicName, d time.Duration, err error)
+	// Failed is called for every message sent to the dead-letter Topic.
+	Failed(subscriber string, topic TopicName)
+}
+
+// NopMetrics discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) Published(TopicName, int)                        {}
+func (NopMetrics) PublishBlocked(TopicName, time.Duration)         {}
+func (NopMetrics) Delivered(string, TopicName)                     {}
+func (NopMetrics) Dropped(string, TopicName)                       {}
+func (NopMetrics) QueueDepth(string, int)                          {}
+func (NopMetrics) Handled(string, TopicName, time.Duration, error) {}
+func (NopMetrics) Failed(string, TopicName)                        {}
+
+// SetMetrics sets the Metrics of the TopicManager. nil restores NopMetrics.
+func (tm *TopicManager) SetMetrics(m Metrics) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.meter = m
+}
+
+func (tm *TopicManager) metrics() Metrics {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.meter == nil {
+		return NopMetrics{}
+	}
+	return tm.meter
+}
+
+// topicOf returns the name of the Topic msg was published to, if it is an Envelope.
+func topicOf(msg interface{}) TopicName {
+	if env, ok := msg.(*Envelope); ok {
+		return env.Topic
+	}
+	return ""
+}
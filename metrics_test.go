@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publishForMetrics publishes 3 messages to a new Topic with a listening
+// Subscriber that fails on the second, and one to a Subscriber that drops it.
+func publishForMetrics(t *testing.T, name string) {
+	topic, _ := NewTopic(TopicName(name), TopicConfig{AllowAllPublishers: true})
+	var h HandlerFunc = func(msg interface{}) error {
+		if msg == 2 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}
+	s, _ := NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 3}, Handlers{"any": &h}, []*Topic{topic})
+	full, _ := NewSubscriberWithConfig("full", SubscriberConfig{Overflow: DropNewest}, nil, []*Topic{topic})
+	if err := topic.Pub(p1, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	_ = s.Close()
+	_ = topic.RemoveSub(full)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics(0.5, 0.001)
+	TM.SetMetrics(m)
+	defer TM.SetMetrics(nil)
+	publishForMetrics(t, "TestPrometheusMetrics")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	got := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE pubsub_published_total counter\n",
+		`pubsub_published_total{topic="TestPrometheusMetrics"} 3` + "\n",
+		`pubsub_delivered_total{subscriber="s",topic="TestPrometheusMetrics"} 3` + "\n",
+		`pubsub_dropped_total{subscriber="full",topic="TestPrometheusMetrics"} 3` + "\n",
+		`pubsub_handled_total{subscriber="s",topic="TestPrometheusMetrics"} 3` + "\n",
+		`pubsub_handler_errors_total{subscriber="s",topic="TestPrometheusMetrics"} 1` + "\n",
+		`pubsub_failed_total{subscriber="s",topic="TestPrometheusMetrics"} 1` + "\n",
+		`pubsub_queue_depth{subscriber="s"} 0` + "\n",
+		"# TYPE pubsub_handler_duration_seconds histogram\n",
+		`pubsub_handler_duration_seconds_bucket{subscriber="s",topic="TestPrometheusMetrics",le="0.5"} 3` + "\n",
+		`pubsub_handler_duration_seconds_bucket{subscriber="s",topic="TestPrometheusMetrics",le="+Inf"} 3` + "\n",
+		`pubsub_handler_duration_seconds_count{subscriber="s",topic="TestPrometheusMetrics"} 3` + "\n",
+		`pubsub_publish_blocked_seconds_count{topic="TestPrometheusMetrics"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "le=\"0.001\"") > strings.Index(got, "le=\"0.5\"") {
+		t.Errorf("buckets are not sorted:\n%s", got)
+	}
+}
+
+func TestPrometheusMetrics_EscapeLabels(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Published(TopicName("a\"b\\c\nd"), 1)
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := `pubsub_published_total{topic="a\"b\\c\nd"} 1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("metrics do not contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	m := NewExpvarMetrics("TestExpvarMetrics_")
+	if NewExpvarMetrics("TestExpvarMetrics_").delivered != m.delivered {
+		t.Errorf("NewExpvarMetrics() with the same prefix does not reuse the maps")
+	}
+	TM.SetMetrics(m)
+	defer TM.SetMetrics(nil)
+	publishForMetrics(t, "TestExpvarMetrics")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "TestExpvarMetrics_published", want: `{"TestExpvarMetrics": 3}`},
+		{name: "TestExpvarMetrics_delivered", want: `{"s": {"TestExpvarMetrics": 3}}`},
+		{name: "TestExpvarMetrics_dropped", want: `{"full": {"TestExpvarMetrics": 3}}`},
+		{name: "TestExpvarMetrics_handled", want: `{"s": {"TestExpvarMetrics": 3}}`},
+		{name: "TestExpvarMetrics_handler_errors", want: `{"s": {"TestExpvarMetrics": 1}}`},
+		{name: "TestExpvarMetrics_failed", want: `{"s": {"TestExpvarMetrics": 1}}`},
+		{name: "TestExpvarMetrics_queue_depth", want: `{"s": 0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := expvar.Get(tt.name)
+			if v == nil {
+				t.Fatalf("%s is not published", tt.name)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+				t.Fatal(err)
+			}
+			_ = json.Unmarshal([]byte(tt.want), &want)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s = %s, want %s", tt.name, v.String(), tt.want)
+			}
+		})
+	}
+	var seconds map[string]map[string]float64
+	_ = json.Unmarshal([]byte(expvar.Get("TestExpvarMetrics_handler_seconds").String()), &seconds)
+	if d := seconds["s"]["TestExpvarMetrics"]; d <= 0 || d > time.Second.Seconds() {
+		t.Errorf("handler_seconds = %v", seconds)
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+// HandlerMiddleware wraps a handler, to run code before and after it or to
+// skip it. Middleware gets the handler context, so the Envelope is available
+// through EnvelopeFromContext. Middleware of the TopicManager runs before the
+// middleware of the Subscriber, in the order it was added. It runs for every
+// attempt of the RetryPolicy.
+type HandlerMiddleware func(next HandlerCtxFunc) HandlerCtxFunc
+
+// PublishFunc publishes a single message wrapped in its Envelope.
+type PublishFunc func(ctx context.Context, env *Envelope) (err error)
+
+// PublishInterceptor wraps publishing, to inspect, change or enrich an
+// Envelope before the message is delivered. Returning an error rejects the
+// message and not calling next drops it. Interceptors of the TopicManager run
+// first, then those of the Publisher and then those of the Topic, each in the
+// order they were added. Type safe Topics check the types of the payloads
+// after all interceptors ran.
+type PublishInterceptor func(next PublishFunc) PublishFunc
+
+// UseHandle adds middleware that wraps the handlers of all Subscribers.
+func (tm *TopicManager) UseHandle(mw ...HandlerMiddleware) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.handleMiddleware = append(tm.handleMiddleware, mw...)
+}
+
+// UsePublish adds interceptors that wrap publishing to all Topics.
+func (tm *TopicManager) UsePublish(interceptors ...PublishInterceptor) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.interceptors = append(tm.interceptors, interceptors...)
+}
+
+// UsePublish adds interceptors that wrap publishing to the Topic.
+func (t *Topic) UsePublish(interceptors ...PublishInterceptor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interceptors = append(t.interceptors, interceptors...)
+}
+
+// UsePublish adds interceptors that wrap publishing by the Publisher.
+func (p *Publisher) UsePublish(interceptors ...PublishInterceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
+// UseHandle adds middleware that wraps the handlers of the Subscriber.
+func (s *Subscriber) UseHandle(mw ...HandlerMiddleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mw...)
+}
+
+// wrapHandler wraps handler in the middleware of the TopicManager and the Subscriber.
+func (s *Subscriber) wrapHandler(handler HandlerCtxFunc) HandlerCtxFunc {
+	tm := s.manager()
+	tm.mu.RLock()
+	mw := append([]HandlerMiddleware(nil), tm.handleMiddleware...)
+	tm.mu.RUnlock()
+	s.mu.RLock()
+	mw = append(mw, s.middleware...)
+	s.mu.RUnlock()
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
+// intercept passes envs through the publish interceptors of the TopicManager,
+// pub and the Topic. It returns the Envelopes that were not dropped, or the
+// first error an interceptor returned.
+func (t *Topic) intercept(ctx context.Context, pub *Publisher, topicInterceptors []PublishInterceptor, envs []*Envelope) (out []*Envelope, err error) {
+	tm := t.manager()
+	tm.mu.RLock()
+	chain := append([]PublishInterceptor(nil), tm.interceptors...)
+	tm.mu.RUnlock()
+	pub.mu.RLock()
+	chain = append(chain, pub.interceptors...)
+	pub.mu.RUnlock()
+	chain = append(chain, topicInterceptors...)
+	if len(chain) == 0 {
+		return envs, nil
+	}
+
+	var publish PublishFunc = func(ctx context.Context, env *Envelope) error {
+		out = append(out, env)
+		return nil
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		publish = chain[i](publish)
+	}
+	for _, env := range envs {
+		if err = publish(ctx, env); err != nil {
+			return nil, fmt.Errorf("message %s rejected: %w", env.ID, err)
+		}
+	}
+	return
+}
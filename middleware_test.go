@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetMiddleware removes the middleware and interceptors added to TM by a test.
+func resetMiddleware() {
+	TM.mu.Lock()
+	defer TM.mu.Unlock()
+	TM.interceptors = nil
+	TM.handleMiddleware = nil
+}
+
+func TestSubscriber_UseHandle(t *testing.T) {
+	defer resetMiddleware()
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) HandlerMiddleware {
+		return func(next HandlerCtxFunc) HandlerCtxFunc {
+			return func(ctx context.Context, msg interface{}) error {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				if msg == "skip" && name == "subscriber" {
+					return nil
+				}
+				return next(ctx, msg)
+			}
+		}
+	}
+	TM.UseHandle(record("manager"))
+
+	topic, _ := NewTopic("TestSubscriber_UseHandle", TopicConfig{AllowAllPublishers: true})
+	done := make(chan interface{}, 2)
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		if _, ok := EnvelopeFromContext(ctx); !ok {
+			t.Errorf("handler context has no Envelope")
+		}
+		mu.Lock()
+		calls = append(calls, "handler")
+		mu.Unlock()
+		done <- msg
+		return nil
+	}
+	s, _ := NewSubscriber("TestSubscriber_UseHandle", nil, []*Topic{topic})
+	_ = s.AddHandlerCtx("any", &h)
+	s.UseHandle(record("subscriber"))
+	s.Listen()
+
+	_ = topic.Pub(p1, "skip", "handle")
+	select {
+	case msg := <-done:
+		if msg != "handle" {
+			t.Errorf("handler got %v, want handle", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	_ = s.Close()
+	want := []string{"manager", "subscriber", "manager", "subscriber", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestTopic_UsePublish(t *testing.T) {
+	tests := []struct {
+		name        string
+		types       Types
+		msg         []interface{}
+		wantErr     bool
+		wantPayload []interface{}
+	}{
+		{name: "Enrich", msg: []interface{}{"a", "b"}, wantPayload: []interface{}{"a", "b"}},
+		{name: "Reject batch", msg: []interface{}{"a", "reject"}, wantErr: true},
+		{name: "Drop", msg: []interface{}{"a", "drop", "b"}, wantPayload: []interface{}{"a", "b"}},
+		{name: "Types checked after change", types: NewTypes(0), msg: []interface{}{"a"}, wantErr: true},
+		{name: "Changed payload allowed", types: NewTypes(0), msg: []interface{}{"int"}, wantPayload: []interface{}{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer resetMiddleware()
+			var order []string
+			interceptor := func(name string) PublishInterceptor {
+				return func(next PublishFunc) PublishFunc {
+					return func(ctx context.Context, env *Envelope) error {
+						order = append(order, name)
+						env.Headers[name] = "seen"
+						switch env.Payload {
+						case "reject":
+							return errors.New("rejected")
+						case "drop":
+							return nil
+						case "int":
+							env.Payload = 0
+						}
+						return next(ctx, env)
+					}
+				}
+			}
+			TM.UsePublish(interceptor("manager"))
+			topic, _ := NewTopic(TopicName("TestTopic_UsePublish "+tt.name), TopicConfig{AllowAllPublishers: true, Types: tt.types})
+			topic.UsePublish(interceptor("topic"))
+			pub := NewPublisher("TestTopic_UsePublish")
+			pub.UsePublish(func(next PublishFunc) PublishFunc {
+				return func(ctx context.Context, env *Envelope) error {
+					order = append(order, "publisher")
+					return next(ctx, env)
+				}
+			})
+			s, _ := NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 10}, nil, []*Topic{topic})
+
+			if err := topic.Pub(pub, tt.msg...); (err != nil) != tt.wantErr {
+				t.Errorf("Pub() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(order) < 3 || !reflect.DeepEqual(order[:3], []string{"manager", "publisher", "topic"}) {
+				t.Errorf("interceptors ran in order %v, want manager, publisher, topic", order)
+			}
+			var payloads []interface{}
+			for s.QueueLen() > 0 {
+				env := (<-s.ch).(*Envelope)
+				payloads = append(payloads, env.Payload)
+				if env.Headers["manager"] != "seen" || env.Headers["topic"] != "seen" {
+					t.Errorf("Headers = %v, want set by interceptors", env.Headers)
+				}
+			}
+			if !reflect.DeepEqual(payloads, tt.wantPayload) {
+				t.Errorf("delivered %v, want %v", payloads, tt.wantPayload)
+			}
+		})
+	}
+}
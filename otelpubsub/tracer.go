@@ -0,0 +1,74 @@
+// Package otelpubsub traces publishing and handling pubsub messages with
+// OpenTelemetry, propagating the trace context as W3C traceparent headers.
+package otelpubsub
+
+import (
+	"context"
+
+	"github.com/georgegkinis/pubsub"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/georgegkinis/pubsub/otelpubsub"
+
+// Tracer is a pubsub.Tracer creating OpenTelemetry spans.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer returns a Tracer creating spans with tp and propagating them with
+// the W3C trace context. When tp is nil the global TracerProvider is used.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{
+		tracer:     tp.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// StartPublish starts a producer span and injects its traceparent into env.Headers.
+func (t *Tracer) StartPublish(ctx context.Context, env *pubsub.Envelope) (context.Context, pubsub.Span) {
+	ctx, span := t.tracer.Start(ctx, string(env.Topic)+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes(env)...))
+	t.propagator.Inject(ctx, propagation.MapCarrier(env.Headers))
+	return ctx, otelSpan{span}
+}
+
+// StartHandle starts a consumer span, a child of the span in the traceparent
+// of env.Headers.
+func (t *Tracer) StartHandle(ctx context.Context, env *pubsub.Envelope) (context.Context, pubsub.Span) {
+	ctx = t.propagator.Extract(ctx, propagation.MapCarrier(env.Headers))
+	ctx, span := t.tracer.Start(ctx, string(env.Topic)+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes(env)...))
+	return ctx, otelSpan{span}
+}
+
+func attributes(env *pubsub.Envelope) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "pubsub"),
+		attribute.String("messaging.destination.name", string(env.Topic)),
+		attribute.String("messaging.message.id", env.ID),
+		attribute.String("messaging.client.id", env.Publisher),
+	}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,105 @@
+package otelpubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/georgegkinis/pubsub"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tm := pubsub.TM
+	tm.UseTracer(NewTracer(tp))
+
+	topic, err := pubsub.NewTopic("otelpubsub.TestTracer", pubsub.TopicConfig{AllowAllPublishers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan trace.SpanContext, 2)
+	var h pubsub.HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		handled <- trace.SpanContextFromContext(ctx)
+		if msg == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	s, _ := pubsub.NewSubscriber("otelpubsub.TestTracer", nil, []*pubsub.Topic{topic})
+	_ = s.AddHandlerCtx("any", &h)
+	s.Listen()
+	defer s.Close()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	if err = pubsub.NewPublisher("p").PubCtx(ctx, topic, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	var handlerSpan trace.SpanContext
+	select {
+	case handlerSpan = <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	_ = s.Close()
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, v := range spans {
+		byName[v.Name] = v
+	}
+	publish, ok := byName["otelpubsub.TestTracer publish"]
+	if !ok {
+		t.Fatalf("no publish span in %v", spans)
+	}
+	process, ok := byName["otelpubsub.TestTracer process"]
+	if !ok {
+		t.Fatalf("no process span in %v", spans)
+	}
+	if publish.Parent.SpanID() != parent.SpanContext().SpanID() || publish.SpanKind != trace.SpanKindProducer {
+		t.Errorf("publish span parent = %v, kind %v, want child of request", publish.Parent.SpanID(), publish.SpanKind)
+	}
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() || !process.Parent.IsRemote() || process.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("process span parent = %v, kind %v, want remote child of publish", process.Parent, process.SpanKind)
+	}
+	if process.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("process span is not in the trace of the request")
+	}
+	if handlerSpan.SpanID() != process.SpanContext.SpanID() {
+		t.Errorf("handler context does not carry the process span")
+	}
+	if process.Status.Code == codes.Error {
+		t.Errorf("process span status = %v", process.Status)
+	}
+}
+
+func TestTracer_StartHandleRecordsError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tr := NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	env := &pubsub.Envelope{ID: "1", Topic: "t", Headers: map[string]string{}}
+
+	_, span := tr.StartPublish(context.Background(), env)
+	span.End(nil)
+	if env.Headers[pubsub.HeaderTraceparent] == "" {
+		t.Fatalf("StartPublish() did not inject traceparent: %v", env.Headers)
+	}
+	_, span = tr.StartHandle(context.Background(), env)
+	span.End(errors.New("failed"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[1].Status.Code != codes.Error || len(spans[1].Events) != 1 {
+		t.Errorf("handle span status = %v, events %v, want error recorded", spans[1].Status, spans[1].Events)
+	}
+	if spans[1].Parent.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Errorf("handle span is not a child of the publish span")
+	}
+}
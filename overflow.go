@@ -0,0 +1,45 @@
+package pubsub
+
+import "time"
+
+// OverflowPolicy decides what happens when a message is delivered to a
+// Subscriber whose queue is full.
+type OverflowPolicy int
+
+const (
+	// Block waits until there is room in the queue.
+	Block OverflowPolicy = iota
+	// BlockWithTimeout waits up to SubscriberConfig.OverflowTimeout for room
+	// in the queue and drops the message afterwards.
+	BlockWithTimeout
+	// DropNewest drops the message being delivered.
+	DropNewest
+	// DropOldest drops the oldest queued message to make room.
+	// On an unbuffered queue it behaves like DropNewest.
+	DropOldest
+	// ErrorOnFull drops the message and returns ErrQueueFull to the publisher.
+	ErrorOnFull
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case Block:
+		return "block"
+	case BlockWithTimeout:
+		return "block with timeout"
+	case DropNewest:
+		return "drop newest"
+	case DropOldest:
+		return "drop oldest"
+	case ErrorOnFull:
+		return "error on full"
+	}
+	return "unknown"
+}
+
+// QueueConfig holds the queue settings of a SubscriberConfig.
+type QueueConfig struct {
+	Size            int
+	Overflow        OverflowPolicy
+	OverflowTimeout time.Duration
+}
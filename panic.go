@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicPolicy decides what happens when a handler panics.
+type PanicPolicy int
+
+const (
+	// RecoverOnPanic turns the panic into a *PanicError, handled like any
+	// other handler error, and keeps the listener running.
+	RecoverOnPanic PanicPolicy = iota
+	// RestartOnPanic recovers like RecoverOnPanic, then replaces the
+	// listener goroutine with a new one.
+	RestartOnPanic
+	// CrashOnPanic does not recover, so the panic crashes the process.
+	CrashOnPanic
+)
+
+func (p PanicPolicy) String() string {
+	switch p {
+	case RecoverOnPanic:
+		return "recover"
+	case RestartOnPanic:
+		return "restart"
+	case CrashOnPanic:
+		return "crash"
+	}
+	return "unknown"
+}
+
+// PanicError is the error a recovered handler panic is turned into.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// call calls handler, turning a panic into a *PanicError unless the
+// PanicPolicy is CrashOnPanic.
+func (s *Subscriber) call(ctx context.Context, handler HandlerCtxFunc, msg interface{}) (err error) {
+	if s.cfg.PanicPolicy != CrashOnPanic {
+		defer func() {
+			if v := recover(); v != nil {
+				s.panics.Add(1)
+				err = &PanicError{Value: v, Stack: debug.Stack()}
+				s.log().Error("handler panicked", "subscriber", s.name, "type", fmt.Sprintf("%T", msg), "panic", v, "stack", string(err.(*PanicError).Stack))
+			}
+		}()
+	}
+	return handler(ctx, msg)
+}
+
+// Panics returns the number of handler panics the Subscriber recovered from.
+func (s *Subscriber) Panics() uint64 {
+	return s.panics.Load()
+}
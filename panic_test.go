@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_PanicPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PanicPolicy
+	}{
+		{name: "Recover", policy: RecoverOnPanic},
+		{name: "Restart", policy: RestartOnPanic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dlq, _ := TM.SetDeadLetterTopic(TopicName("TestSubscriber_PanicPolicy dlq " + tt.name))
+			defer func() {
+				_ = TM.DeleteTopic(dlq.Name())
+			}()
+			letters := make(chan DeadLetter, 10)
+			var collect HandlerFunc = func(msg interface{}) error {
+				letters <- msg.(DeadLetter)
+				return nil
+			}
+			dlqSub, _ := NewSubscriber("dlq", Handlers{"any": &collect}, []*Topic{dlq})
+			dlqSub.Listen()
+			defer dlqSub.Close()
+
+			topic, _ := NewTopic(TopicName("TestSubscriber_PanicPolicy "+tt.name), TopicConfig{AllowAllPublishers: true})
+			handled := make(chan interface{}, 10)
+			var h HandlerFunc = func(msg interface{}) error {
+				if msg == "panic" {
+					panic("handler bug")
+				}
+				handled <- msg
+				return nil
+			}
+			s, _ := NewSubscriberWithConfig("s", SubscriberConfig{PanicPolicy: tt.policy}, Handlers{"any": &h}, []*Topic{topic})
+			s.Listen()
+			p := NewPublisher("p")
+
+			if err := p.Pub(topic, "panic"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case dl := <-letters:
+				var pe *PanicError
+				if !errors.As(dl.Err, &pe) || pe.Value != "handler bug" {
+					t.Fatalf("DeadLetter error = %v, want *PanicError", dl.Err)
+				}
+				if !strings.Contains(string(pe.Stack), "TestSubscriber_PanicPolicy") {
+					t.Errorf("PanicError stack does not contain the handler:\n%s", pe.Stack)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no dead letter received")
+			}
+			if got := s.Panics(); got != 1 {
+				t.Errorf("Panics() = %d, want 1", got)
+			}
+
+			if err := p.Pub(topic, "ok"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("subscriber stopped after panic")
+			}
+			if err := s.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSubscriber_CrashOnPanic(t *testing.T) {
+	s, _ := NewSubscriberWithConfig("TestSubscriber_CrashOnPanic", SubscriberConfig{PanicPolicy: CrashOnPanic}, nil, nil)
+	var h HandlerFunc = func(msg interface{}) error {
+		panic("handler bug")
+	}
+	defer func() {
+		if v := recover(); v != "handler bug" {
+			t.Errorf("recovered %v, want handler bug", v)
+		}
+		if got := s.Panics(); got != 0 {
+			t.Errorf("Panics() = %d, want 0", got)
+		}
+	}()
+	_ = s.call(context.Background(), handlerEntry{fn: &h}.call, 1)
+	t.Error("call() did not panic")
+}
+
+func TestPanicError_Unwrap(t *testing.T) {
+	errBug := errors.New("bug")
+	if err := error(&PanicError{Value: errBug}); !errors.Is(err, errBug) {
+		t.Errorf("PanicError with error value does not unwrap to it")
+	}
+	if err := (&PanicError{Value: "bug"}).Unwrap(); err != nil {
+		t.Errorf("Unwrap() = %v, want nil", err)
+	}
+}
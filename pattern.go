@@ -0,0 +1,174 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topic names are hierarchical, their levels separated by '.' or '/',
+// e.g. "orders.eu.created" or "orders/eu/created".
+// A pattern matches topic names level by level:
+//   - "*" matches exactly one level, "orders.*.created" matches "orders.eu.created".
+//   - ">" or "#" as the last level matches one or more levels, "orders.>" matches
+//     "orders.eu" and "orders.eu.created" but not "orders".
+const (
+	WildcardOne  = "*"
+	WildcardMore = ">"
+	WildcardHash = "#"
+)
+
+// splitLevels splits a topic name or pattern into its levels.
+func splitLevels(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == '.' || r == '/'
+	})
+}
+
+// ValidatePattern returns an error if pattern has empty levels or a
+// multi-level wildcard that is not the last level.
+func ValidatePattern(pattern string) (err error) {
+	if pattern == "" {
+		return fmt.Errorf("empty topic pattern")
+	}
+	levels := splitLevels(pattern)
+	if len(levels) != strings.Count(pattern, ".")+strings.Count(pattern, "/")+1 {
+		return fmt.Errorf("topic pattern %s has an empty level", pattern)
+	}
+	for i, l := range levels {
+		if (l == WildcardMore || l == WildcardHash) && i != len(levels)-1 {
+			return fmt.Errorf("multi-level wildcard %s must be the last level of topic pattern %s", l, pattern)
+		}
+	}
+	return
+}
+
+// MatchTopic reports whether the topic name matches pattern.
+func MatchTopic(pattern string, name TopicName) bool {
+	p, n := splitLevels(pattern), splitLevels(string(name))
+	for i, l := range p {
+		if l == WildcardMore || l == WildcardHash {
+			return len(n) > i
+		}
+		if i >= len(n) || (l != WildcardOne && l != n[i]) {
+			return false
+		}
+	}
+	return len(p) == len(n)
+}
+
+// patternSub is a Subscriber subscribed to all topics matching pattern.
+type patternSub struct {
+	pattern string
+	sub     *Subscriber
+}
+
+// SubPattern subscribes the Subscriber to all registered Topics matching
+// pattern, and to Topics registered later that match it. The reply inboxes
+// of Publisher.Request never match.
+func (s *Subscriber) SubPattern(pattern string) (err error) {
+	return s.manager().subscribePattern(s, pattern)
+}
+
+// UnsubPattern stops subscribing the Subscriber to new Topics matching pattern
+// and unsubscribes it from the registered Topics matching pattern.
+func (s *Subscriber) UnsubPattern(pattern string) (err error) {
+	return s.manager().unsubscribePattern(s, pattern)
+}
+
+func (tm *TopicManager) subscribePattern(s *Subscriber, pattern string) (err error) {
+	if err = ValidatePattern(pattern); err != nil {
+		return
+	}
+	if s.isClosed() {
+		return fmt.Errorf("cannot subscribe subscriber %s to pattern %s: %w", s.Name(), pattern, ErrClosed)
+	}
+	tm.mu.Lock()
+	for _, v := range tm.patterns {
+		if v.sub == s && v.pattern == pattern {
+			tm.mu.Unlock()
+			return fmt.Errorf("subscriber %s is already subscribed to pattern %s", s.Name(), pattern)
+		}
+	}
+	tm.patterns = append(tm.patterns, patternSub{pattern: pattern, sub: s})
+	matching := tm.matching(pattern)
+	tm.mu.Unlock()
+
+	for _, t := range matching {
+		if _, ok := s.GetSubscriptions()[t.Name()]; ok {
+			continue
+		}
+		if err = t.AddSub(s); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// dropPatterns forgets all patterns of s, so it is not subscribed to new
+// Topics anymore.
+func (tm *TopicManager) dropPatterns(s *Subscriber) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	kept := tm.patterns[:0]
+	for _, v := range tm.patterns {
+		if v.sub != s {
+			kept = append(kept, v)
+		}
+	}
+	for i := len(kept); i < len(tm.patterns); i++ {
+		tm.patterns[i] = patternSub{}
+	}
+	tm.patterns = kept
+}
+
+func (tm *TopicManager) unsubscribePattern(s *Subscriber, pattern string) (err error) {
+	tm.mu.Lock()
+	found := false
+	for i, v := range tm.patterns {
+		if v.sub == s && v.pattern == pattern {
+			tm.patterns = append(tm.patterns[:i], tm.patterns[i+1:]...)
+			found = true
+			break
+		}
+	}
+	matching := tm.matching(pattern)
+	tm.mu.Unlock()
+	if !found {
+		return fmt.Errorf("subscriber %s is not subscribed to pattern %s", s.Name(), pattern)
+	}
+
+	for _, t := range matching {
+		if _, ok := s.GetSubscriptions()[t.Name()]; ok {
+			_ = t.RemoveSub(s)
+		}
+	}
+	return
+}
+
+// matching returns the registered Topics matching pattern. tm.mu must be held.
+func (tm *TopicManager) matching(pattern string) (t []*Topic) {
+	for n, v := range tm.topics {
+		if matchSubscribable(pattern, n) {
+			t = append(t, v)
+		}
+	}
+	return
+}
+
+// patternSubscribers returns the Subscribers with a pattern matching n. tm.mu must be held.
+func (tm *TopicManager) patternSubscribers(n TopicName) (subs []*Subscriber) {
+	seen := make(map[*Subscriber]bool)
+	for _, v := range tm.patterns {
+		if !seen[v.sub] && matchSubscribable(v.pattern, n) {
+			seen[v.sub] = true
+			subs = append(subs, v.sub)
+		}
+	}
+	return
+}
+
+// matchSubscribable is MatchTopic for the Topics patterns subscribe to,
+// which are all but the reply inboxes.
+func matchSubscribable(pattern string, n TopicName) bool {
+	return !strings.HasPrefix(string(n), inboxPrefix) && MatchTopic(pattern, n)
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    TopicName
+		want    bool
+	}{
+		{pattern: "orders.eu.created", name: "orders.eu.created", want: true},
+		{pattern: "orders.eu.created", name: "orders.us.created", want: false},
+		{pattern: "orders.*.created", name: "orders.eu.created", want: true},
+		{pattern: "orders/*/created", name: "orders/eu/created", want: true},
+		{pattern: "orders.*.created", name: "orders.eu.deleted", want: false},
+		{pattern: "orders.*.created", name: "orders.eu.west.created", want: false},
+		{pattern: "orders.*", name: "orders", want: false},
+		{pattern: "orders.>", name: "orders.eu", want: true},
+		{pattern: "orders.>", name: "orders.eu.created", want: true},
+		{pattern: "orders.>", name: "orders", want: false},
+		{pattern: "orders/#", name: "orders/eu/created", want: true},
+		{pattern: "*.*.created", name: "orders.eu.created", want: true},
+		{pattern: ">", name: "orders", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+string(tt.name), func(t *testing.T) {
+			if got := MatchTopic(tt.pattern, tt.name); got != tt.want {
+				t.Errorf("MatchTopic(%s, %s) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "orders.*.created", wantErr: false},
+		{pattern: "orders.>", wantErr: false},
+		{pattern: "orders/#", wantErr: false},
+		{pattern: "", wantErr: true},
+		{pattern: "orders..created", wantErr: true},
+		{pattern: ".orders", wantErr: true},
+		{pattern: "orders.", wantErr: true},
+		{pattern: "orders.>.created", wantErr: true},
+		{pattern: "#.created", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if err := ValidatePattern(tt.pattern); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubscriber_SubPattern(t *testing.T) {
+	existing, _ := NewTopic("TestSubPattern.orders.eu.created", TopicConfig{AllowAllPublishers: true})
+	other, _ := NewTopic("TestSubPattern.orders.eu.deleted", TopicConfig{AllowAllPublishers: true})
+
+	received := make(chan TopicName, 10)
+	var h HandlerFunc = func(msg interface{}) error {
+		received <- msg.(TopicName)
+		return nil
+	}
+	s, _ := NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, nil)
+	if err := s.SubPattern("TestSubPattern.orders.*.created"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubPattern("TestSubPattern.orders.*.created"); err == nil {
+		t.Errorf("SubPattern() twice error = nil, want error")
+	}
+	s.Listen()
+
+	later, _ := NewTopic("TestSubPattern.orders.us.created", TopicConfig{AllowAllPublishers: true})
+	for _, topic := range []*Topic{existing, other, later} {
+		if err := topic.Pub(p1, topic.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(map[TopicName]bool)
+	for len(got) < 2 {
+		select {
+		case n := <-received:
+			got[n] = true
+		case <-time.After(time.Second):
+			t.Fatalf("received from %v, want %s and %s", got, existing.Name(), later.Name())
+		}
+	}
+	if !got[existing.Name()] || !got[later.Name()] {
+		t.Errorf("received from %v, want %s and %s", got, existing.Name(), later.Name())
+	}
+
+	if err := s.UnsubPattern("TestSubPattern.orders.*.created"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.GetSubscriptions()) != 0 {
+		t.Errorf("GetSubscriptions() = %v after UnsubPattern, want none", s.GetSubscriptions())
+	}
+	_, _ = NewTopic("TestSubPattern.orders.asia.created", TopicConfig{})
+	if len(s.GetSubscriptions()) != 0 {
+		t.Errorf("subscribed to new Topic after UnsubPattern")
+	}
+}
+
+func TestSubscriber_SubPatternClosed(t *testing.T) {
+	tm := NewTopicManager()
+	s, _ := tm.NewSubscriber("s", nil, nil)
+	if err := s.SubPattern("orders.*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	topic, _ := tm.NewTopic("orders.a", TopicConfig{})
+	if got := topic.Subscribers(); len(got) != 0 {
+		t.Errorf("Subscribers() = %v, want none", got)
+	}
+	if len(tm.patterns) != 0 {
+		t.Errorf("TopicManager keeps %d patterns of closed subscriber", len(tm.patterns))
+	}
+	if err := topic.AddSub(s); !errors.Is(err, ErrClosed) {
+		t.Errorf("AddSub() of closed subscriber error = %v, want ErrClosed", err)
+	}
+	if err := s.SubPattern("orders.*"); !errors.Is(err, ErrClosed) {
+		t.Errorf("SubPattern() of closed subscriber error = %v, want ErrClosed", err)
+	}
+}
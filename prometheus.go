@@ -0,0 +1,193 @@
+package pubsub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the histogram buckets
+// of PrometheusMetrics.
+var DefaultBuckets = []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// PrometheusMetrics collects Metrics in memory and exposes them in the
+// Prometheus text format through WriteTo or as an http.Handler.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[promSeries]float64
+	gauges     map[promSeries]float64
+	histograms map[promSeries]*histogram
+}
+
+// promSeries is a metric name with its rendered labels.
+type promSeries struct {
+	name   string
+	labels string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var promHelp = map[string]struct{ typ, help string }{
+	"pubsub_published_total":          {"counter", "Messages accepted for delivery by a topic."},
+	"pubsub_publish_blocked_seconds":  {"histogram", "Time a publish spent delivering to the subscriber queues."},
+	"pubsub_delivered_total":          {"counter", "Messages queued for a subscriber."},
+	"pubsub_dropped_total":            {"counter", "Messages a subscriber dropped without handling them."},
+	"pubsub_queue_depth":              {"gauge", "Messages queued for a subscriber."},
+	"pubsub_handled_total":            {"counter", "Handler calls."},
+	"pubsub_handler_errors_total":     {"counter", "Handler calls that returned an error."},
+	"pubsub_handler_duration_seconds": {"histogram", "Duration of handler calls."},
+	"pubsub_failed_total":             {"counter", "Messages sent to the dead-letter topic."},
+}
+
+// NewPrometheusMetrics returns PrometheusMetrics with histograms using
+// buckets, or DefaultBuckets when none are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &PrometheusMetrics{
+		buckets:    b,
+		counters:   make(map[promSeries]float64),
+		gauges:     make(map[promSeries]float64),
+		histograms: make(map[promSeries]*histogram),
+	}
+}
+
+func (p *PrometheusMetrics) Published(topic TopicName, n int) {
+	p.add("pubsub_published_total", float64(n), "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) PublishBlocked(topic TopicName, d time.Duration) {
+	p.observe("pubsub_publish_blocked_seconds", d, "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) Delivered(subscriber string, topic TopicName) {
+	p.add("pubsub_delivered_total", 1, "subscriber", subscriber, "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) Dropped(subscriber string, topic TopicName) {
+	p.add("pubsub_dropped_total", 1, "subscriber", subscriber, "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) QueueDepth(subscriber string, depth int) {
+	s := promSeries{name: "pubsub_queue_depth", labels: promLabels("subscriber", subscriber)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gauges[s] = float64(depth)
+}
+
+func (p *PrometheusMetrics) Handled(subscriber string, topic TopicName, d time.Duration, err error) {
+	p.add("pubsub_handled_total", 1, "subscriber", subscriber, "topic", string(topic))
+	if err != nil {
+		p.add("pubsub_handler_errors_total", 1, "subscriber", subscriber, "topic", string(topic))
+	}
+	p.observe("pubsub_handler_duration_seconds", d, "subscriber", subscriber, "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) Failed(subscriber string, topic TopicName) {
+	p.add("pubsub_failed_total", 1, "subscriber", subscriber, "topic", string(topic))
+}
+
+func (p *PrometheusMetrics) add(name string, v float64, labels ...string) {
+	s := promSeries{name: name, labels: promLabels(labels...)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters[s] += v
+}
+
+func (p *PrometheusMetrics) observe(name string, d time.Duration, labels ...string) {
+	s := promSeries{name: name, labels: promLabels(labels...)}
+	v := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.histograms[s]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.histograms[s] = h
+	}
+	for i, b := range p.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// WriteTo writes all metrics to w in the Prometheus text format.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (n int64, err error) {
+	var b strings.Builder
+	p.mu.Lock()
+	series := make(map[string][]promSeries)
+	for s := range p.counters {
+		series[s.name] = append(series[s.name], s)
+	}
+	for s := range p.gauges {
+		series[s.name] = append(series[s.name], s)
+	}
+	for s := range p.histograms {
+		series[s.name] = append(series[s.name], s)
+	}
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ss := series[name]
+		sort.Slice(ss, func(i, j int) bool { return ss[i].labels < ss[j].labels })
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, promHelp[name].help, name, promHelp[name].typ)
+		for _, s := range ss {
+			switch promHelp[name].typ {
+			case "counter":
+				fmt.Fprintf(&b, "%s{%s} %s\n", name, s.labels, promFloat(p.counters[s]))
+			case "gauge":
+				fmt.Fprintf(&b, "%s{%s} %s\n", name, s.labels, promFloat(p.gauges[s]))
+			case "histogram":
+				h := p.histograms[s]
+				for i, le := range p.buckets {
+					fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", name, s.labels, promFloat(le), h.counts[i])
+				}
+				fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, s.labels, h.count)
+				fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, s.labels, promFloat(h.sum))
+				fmt.Fprintf(&b, "%s_count{%s} %d\n", name, s.labels, h.count)
+			}
+		}
+	}
+	p.mu.Unlock()
+	written, err := io.WriteString(w, b.String())
+	return int64(written), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels renders alternating label names and values.
+func promLabels(labels ...string) string {
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+promEscaper.Replace(labels[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
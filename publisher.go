@@ -1,9 +1,9 @@
 package pubsub
 
 import (
+	"context"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	"reflect"
+	"sync"
 )
 
 type PublisherIF interface {
@@ -11,38 +11,54 @@ type PublisherIF interface {
 	Name() string
 	GetSubscriptions() Subscriptions
 	Pub(topic *Topic, msg any) error
+	PubCtx(ctx context.Context, topic *Topic, msg any) error
 	PubAll(msg any) error
+	PubAllCtx(ctx context.Context, msg any) error
 	AddSubscription(topic *Topic) error
+	RemoveSubscription(topic *Topic) error
 }
 
 type TypeChecker interface {
 	CheckType(topicName TopicName, checkMsg interface{}) (bool, error)
 }
 
+// Publisher is safe for concurrent use.
 type Publisher struct {
+	mu            sync.RWMutex
 	name          string
 	subscriptions Subscriptions
+	onRemoval     RemovalFunc
+	replies       *inbox
+	interceptors  []PublishInterceptor
+	tm            *TopicManager
 }
 
+// NewPublisher creates a Publisher of the default TopicManager TM.
 func NewPublisher(name string) *Publisher {
+	return TM.NewPublisher(name)
+}
+
+// NewPublisher creates a Publisher of the TopicManager.
+// It can only publish to Topics of the same TopicManager.
+func (tm *TopicManager) NewPublisher(name string) *Publisher {
 	return &Publisher{
 		name:          name,
-		subscriptions: make(Subscriptions)}
+		subscriptions: make(Subscriptions),
+		tm:            tm}
 }
 
 func (p *Publisher) CheckType(topicName TopicName, checkMsg interface{}) (typeOk bool, err error) {
 
-	t := reflect.TypeOf(checkMsg).Name()
-	topic := TM.Topic(topicName)
-	if topic == nil {
+	topic, ok := p.manager().get(topicName)
+	if !ok {
 		err = fmt.Errorf("non-existing topic %s", topicName)
 		return
 	}
-	_, typeOk = TM.Topic(topicName).Types()[t]
+	typeOk = topic.CheckTypes(checkMsg) == nil
 	if typeOk {
-		log.Debugf("topic %s allows Type %T", topicName, checkMsg)
+		p.manager().log().Debug("topic allows type", "topic", topicName, "publisher", p.Name(), "type", fmt.Sprintf("%T", checkMsg))
 	} else {
-		log.Debugf("topic %s does not allow Type %T", topicName, checkMsg)
+		p.manager().log().Debug("topic does not allow type", "topic", topicName, "publisher", p.Name(), "type", fmt.Sprintf("%T", checkMsg))
 	}
 	return
 
@@ -52,28 +68,122 @@ func (p *Publisher) Name() string {
 	return p.name
 }
 
+// GetSubscriptions returns a copy of the Topics the Publisher is subscribed to.
 func (p *Publisher) GetSubscriptions() Subscriptions {
-	return p.subscriptions
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s := make(Subscriptions, len(p.subscriptions))
+	for k, v := range p.subscriptions {
+		s[k] = v
+	}
+	return s
 }
 
 func (p *Publisher) Pub(topic *Topic, msg any) (err error) {
-	if err = topic.Pub(p, msg); err != nil {
+	return p.PubCtx(context.Background(), topic, msg)
+}
+
+// PubCtx publishes msg to topic, see Topic.PubCtx.
+func (p *Publisher) PubCtx(ctx context.Context, topic *Topic, msg any) (err error) {
+	if err = topic.PubCtx(ctx, p, msg); err != nil {
 		err = fmt.Errorf("publisher %s failed to publish to topic %s.\nmessage: %v, \nreason: %w", p.Name(), topic.Name(), msg, err)
 		return err
 	}
 	return
 }
 
+// PubAll publishes msg to every subscribed Topic. The message is type checked
+// against all Topics first, so it is either published to all of them or to none.
 func (p *Publisher) PubAll(msg any) (err error) {
-	for _, v := range p.subscriptions {
-		if err = p.Pub(v, msg); err != nil {
+	return p.PubAllCtx(context.Background(), msg)
+}
+
+// PubAllCtx publishes msg to every subscribed Topic, see PubAll and Topic.PubCtx.
+func (p *Publisher) PubAllCtx(ctx context.Context, msg any) (err error) {
+	subscriptions := p.GetSubscriptions()
+	for _, v := range subscriptions {
+		if err = v.CheckTypes(msg); err != nil {
+			err = fmt.Errorf("publisher %s failed to publish to topic %s.\nmessage: %v, \nreason: %w", p.Name(), v.Name(), msg, err)
+			return err
+		}
+	}
+	for _, v := range subscriptions {
+		if err = p.PubCtx(ctx, v, msg); err != nil {
 			return err
 		}
 	}
 	return
 }
 
+// AddSubscription adds topic to the Topics PubAll publishes to. The
+// Publisher is notified when topic is deleted.
 func (p *Publisher) AddSubscription(topic *Topic) (err error) {
-	p.subscriptions[topic.Name()] = topic
+	if err = topic.addPublisherSubscription(p); err != nil {
+		return
+	}
+	name := topic.Name()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscriptions[name] = topic
 	return
 }
+
+// RemoveSubscription removes topic from the Topics PubAll publishes to
+// and notifies the publisher.
+func (p *Publisher) RemoveSubscription(topic *Topic) (err error) {
+	name := topic.Name()
+	ok := false
+	p.mu.RLock()
+	for _, v := range p.subscriptions {
+		ok = ok || v == topic
+	}
+	p.mu.RUnlock()
+	if !ok {
+		err = fmt.Errorf("publisher %s is not subscribed to topic %s", p.Name(), name)
+		return
+	}
+	topic.removePublisherSubscription(p)
+	p.removeSubscription(name, topic, Unsubscribed)
+	return
+}
+
+// OnRemoval registers fn to be called whenever the Publisher loses a Topic.
+func (p *Publisher) OnRemoval(fn RemovalFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onRemoval = fn
+}
+
+// renameSubscription moves topic from old to name after it was renamed.
+func (p *Publisher) renameSubscription(old, name TopicName, topic *Topic) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subscriptions[old] == topic {
+		delete(p.subscriptions, old)
+		p.subscriptions[name] = topic
+	}
+}
+
+// removeSubscription forgets topic, under whichever name it was added, and
+// notifies the RemovalFunc.
+func (p *Publisher) removeSubscription(name TopicName, topic *Topic, reason RemovalReason) {
+	p.mu.Lock()
+	for k, v := range p.subscriptions {
+		if v == topic {
+			delete(p.subscriptions, k)
+		}
+	}
+	fn := p.onRemoval
+	p.mu.Unlock()
+	if fn != nil {
+		fn(RemovalEvent{Topic: name, Reason: reason})
+	}
+}
+
+// manager returns the TopicManager the Publisher belongs to.
+func (p *Publisher) manager() *TopicManager {
+	if p.tm == nil {
+		return TM
+	}
+	return p.tm
+}
@@ -5,6 +5,8 @@ import (
 	"testing"
 )
 
+var typeSafeTopic, _ = NewTopic("typeSafeTopic", TopicConfig{Types: NewTypes("")})
+
 func TestPublisher_CheckType(t *testing.T) {
 	type fields struct {
 		name          string
@@ -21,7 +23,30 @@ func TestPublisher_CheckType(t *testing.T) {
 		wantTypeOk bool
 		wantErr    bool
 	}{
-		// TODO: Add test cases.
+		{name: "Non-existing topic",
+			fields: fields{name: "p1"},
+			args: args{
+				topicName: "CheckType non-existing topic",
+				checkMsg:  "42",
+			}, wantTypeOk: false, wantErr: true},
+		{name: "Topic not TypeSafe",
+			fields: fields{name: "p1"},
+			args: args{
+				topicName: stringTopic.Name(),
+				checkMsg:  42,
+			}, wantTypeOk: true, wantErr: false},
+		{name: "Topic TypeSafe, type allowed",
+			fields: fields{name: "p1"},
+			args: args{
+				topicName: typeSafeTopic.Name(),
+				checkMsg:  "42",
+			}, wantTypeOk: true, wantErr: false},
+		{name: "Topic TypeSafe, type not allowed",
+			fields: fields{name: "p1"},
+			args: args{
+				topicName: typeSafeTopic.Name(),
+				checkMsg:  42,
+			}, wantTypeOk: false, wantErr: false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -90,3 +115,29 @@ func TestPublisher_Name(t *testing.T) {
 		})
 	}
 }
+
+func TestPublisher_RemoveSubscription(t *testing.T) {
+	tests := []struct {
+		name       string
+		subscribed bool
+		wantErr    bool
+	}{
+		{name: "Remove subscribed Topic", subscribed: true, wantErr: false},
+		{name: "Remove not subscribed Topic", subscribed: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, _ := NewTopic(TopicName("TestPublisher_RemoveSubscription "+tt.name), TopicConfig{})
+			p := NewPublisher("p")
+			if tt.subscribed {
+				_ = p.AddSubscription(topic)
+			}
+			if err := p.RemoveSubscription(topic); (err != nil) != tt.wantErr {
+				t.Errorf("RemoveSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := p.GetSubscriptions(); len(got) != 0 {
+				t.Errorf("GetSubscriptions() = %v, want empty", got)
+			}
+		})
+	}
+}
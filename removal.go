@@ -0,0 +1,45 @@
+package pubsub
+
+// RemovalReason describes why a Subscriber or Publisher lost a Topic.
+type RemovalReason int
+
+const (
+	// Unsubscribed is used when a Subscriber is removed from a Topic.
+	Unsubscribed RemovalReason = iota
+	// PublisherRemoved is used when a Publisher is removed from a Topic.
+	PublisherRemoved
+	// TopicDeleted is used when the Topic was deleted from its TopicManager.
+	TopicDeleted
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case Unsubscribed:
+		return "unsubscribed"
+	case PublisherRemoved:
+		return "publisher removed"
+	case TopicDeleted:
+		return "topic deleted"
+	}
+	return "unknown"
+}
+
+// RemovalEvent is passed to the RemovalFunc of a Subscriber or Publisher
+// whenever it loses one of its Topics.
+type RemovalEvent struct {
+	Topic  TopicName
+	Reason RemovalReason
+}
+
+type RemovalFunc func(ev RemovalEvent)
+
+// RemovalPolicy decides what happens to messages that are already queued
+// for a Subscriber when it loses the Topic they were published to.
+type RemovalPolicy int
+
+const (
+	// DrainOnRemoval delivers queued messages to the handlers.
+	DrainOnRemoval RemovalPolicy = iota
+	// DropOnRemoval discards queued messages.
+	DropOnRemoval
+)
@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Headers used to route replies back to a requesting Publisher.
+const (
+	HeaderReplyTo       = "reply-to"
+	HeaderCorrelationID = "correlation-id"
+	HeaderError         = "error"
+)
+
+// ResponderFunc handles a request and returns the reply routed back to the requester.
+type ResponderFunc func(ctx context.Context, msg interface{}) (reply interface{}, err error)
+
+// Reply is a reply received by RequestAll.
+type Reply struct {
+	Payload interface{}
+	// Err is a *ReplyError when the responder returned an error.
+	Err      error
+	Envelope *Envelope
+}
+
+// ReplyError is the error returned by a responder, as received by the requester.
+type ReplyError struct {
+	Responder string
+	Msg       string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("responder %s returned error: %s", e.Responder, e.Msg)
+}
+
+// inbox is the Topic a Publisher receives replies on.
+type inbox struct {
+	topic   *Topic
+	sub     *Subscriber
+	mu      sync.Mutex
+	pending map[string]chan *Envelope
+}
+
+// Request publishes msg to topic and waits for the first reply until ctx is done.
+// Replies are sent by handlers registered with Subscriber.Respond.
+func (p *Publisher) Request(ctx context.Context, topic *Topic, msg interface{}) (reply interface{}, err error) {
+	ch, done, err := p.request(ctx, topic, msg)
+	if err != nil {
+		return
+	}
+	defer done()
+	select {
+	case env := <-ch:
+		r := newReply(env)
+		return r.Payload, r.Err
+	case <-ctx.Done():
+		err = fmt.Errorf("request of publisher %s to topic %s: %w", p.Name(), topic.Name(), ctx.Err())
+		return
+	}
+}
+
+// RequestAll publishes msg to topic and collects the replies of its subscribers
+// until all subscribers at the time of publishing have replied or ctx is done.
+// A consumer group replies once, from the member that got msg.
+// Reaching the deadline of ctx is not an error.
+func (p *Publisher) RequestAll(ctx context.Context, topic *Topic, msg interface{}) (replies []Reply, err error) {
+	expected := topic.receivers()
+	ch, done, err := p.request(ctx, topic, msg)
+	if err != nil {
+		return
+	}
+	defer done()
+	for len(replies) < expected {
+		select {
+		case env := <-ch:
+			replies = append(replies, newReply(env))
+		case <-ctx.Done():
+			return
+		}
+	}
+	return
+}
+
+// request publishes msg with reply routing headers. Replies are received on ch
+// until done is called.
+func (p *Publisher) request(ctx context.Context, topic *Topic, msg interface{}) (ch chan *Envelope, done func(), err error) {
+	in, err := p.inbox()
+	if err != nil {
+		return
+	}
+	id := newID()
+	ch = make(chan *Envelope, len(topic.Subscribers())+1)
+	in.mu.Lock()
+	in.pending[id] = ch
+	in.mu.Unlock()
+	done = func() {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		delete(in.pending, id)
+	}
+
+	ctx = WithHeaders(ctx, map[string]string{
+		HeaderReplyTo:       string(in.topic.Name()),
+		HeaderCorrelationID: id,
+	})
+	if err = p.PubCtx(ctx, topic, msg); err != nil {
+		done()
+	}
+	return
+}
+
+// inboxPrefix starts the names of the inbox Topics, which patterns do not match.
+const inboxPrefix = "_INBOX."
+
+// inbox returns the Publisher's inbox, creating it on first use.
+func (p *Publisher) inbox() (in *inbox, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.replies != nil {
+		return p.replies, nil
+	}
+	in = &inbox{pending: make(map[string]chan *Envelope)}
+	tm := p.manager()
+	in.topic, err = tm.NewTopic(TopicName(inboxPrefix+p.name+"."+newID()), TopicConfig{AllowAllPublishers: true})
+	if err != nil {
+		return nil, err
+	}
+	in.sub, err = tm.NewSubscriberWithConfig(string(in.topic.Name()), SubscriberConfig{QueueSize: 64}, nil, []*Topic{in.topic})
+	if err != nil {
+		return nil, err
+	}
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		env, _ := EnvelopeFromContext(ctx)
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		ch, ok := in.pending[env.Headers[HeaderCorrelationID]]
+		if !ok {
+			return fmt.Errorf("no pending request for reply %s from %s", env.Headers[HeaderCorrelationID], env.Publisher)
+		}
+		select {
+		case ch <- env:
+		default:
+		}
+		return nil
+	}
+	_ = in.sub.AddHandlerCtx("any", &h)
+	in.sub.Listen()
+	p.replies = in
+	return
+}
+
+func newReply(env *Envelope) (r Reply) {
+	r = Reply{Payload: env.Payload, Envelope: env}
+	if msg, ok := env.Headers[HeaderError]; ok {
+		r.Err = &ReplyError{Responder: env.Publisher, Msg: msg}
+	}
+	return
+}
+
+// Respond registers handler for messages of the type of typeOf, see AddHandler.
+// When a message was sent with Publisher.Request or RequestAll, the reply
+// returned by handler, or its error, is published back to the requester.
+func (s *Subscriber) Respond(typeOf interface{}, handler ResponderFunc) (err error) {
+	if handler == nil {
+		err = fmt.Errorf("Required: handler for Subscriber %s", s.Name())
+		return
+	}
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		reply, err := handler(ctx, msg)
+		env, ok := EnvelopeFromContext(ctx)
+		if !ok || env.Headers[HeaderReplyTo] == "" {
+			return err
+		}
+		topic, ok := s.manager().get(TopicName(env.Headers[HeaderReplyTo]))
+		if !ok {
+			return fmt.Errorf("reply-to topic %s of request %s does not exist", env.Headers[HeaderReplyTo], env.ID)
+		}
+		headers := map[string]string{HeaderCorrelationID: env.Headers[HeaderCorrelationID]}
+		if err != nil {
+			headers[HeaderError] = err.Error()
+		}
+		return s.replier().PubCtx(WithHeaders(ctx, headers), topic, reply)
+	}
+	return s.AddHandlerCtx(typeOf, &h)
+}
+
+// replier returns the Publisher the Subscriber sends replies with.
+func (s *Subscriber) replier() *Publisher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replyPub == nil {
+		s.replyPub = s.manager().NewPublisher(s.name)
+	}
+	return s.replyPub
+}
@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPublisher_Request(t *testing.T) {
+	tests := []struct {
+		name      string
+		responder ResponderFunc
+		wantReply interface{}
+		wantErr   bool
+	}{
+		{name: "Responder replies",
+			responder: func(ctx context.Context, msg interface{}) (interface{}, error) {
+				return msg.(int) * 2, nil
+			}, wantReply: 42, wantErr: false},
+		{name: "Responder returns error",
+			responder: func(ctx context.Context, msg interface{}) (interface{}, error) {
+				return nil, fmt.Errorf("cannot handle %v", msg)
+			}, wantReply: nil, wantErr: true},
+		{name: "No responder times out",
+			responder: nil, wantReply: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, _ := NewTopic(TopicName("TestPublisher_Request "+tt.name), TopicConfig{AllowAllPublishers: true})
+			if tt.responder != nil {
+				s, _ := NewSubscriber("responder", nil, []*Topic{topic})
+				if err := s.Respond(0, tt.responder); err != nil {
+					t.Fatal(err)
+				}
+				s.Listen()
+			}
+			p := NewPublisher("TestPublisher_Request requester")
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			reply, err := p.Request(ctx, topic, 21)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Request() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reply != tt.wantReply {
+				t.Errorf("Request() reply = %v, want %v", reply, tt.wantReply)
+			}
+			var replyErr *ReplyError
+			if tt.wantErr && tt.responder != nil && !errors.As(err, &replyErr) {
+				t.Errorf("Request() error = %v, want *ReplyError", err)
+			}
+		})
+	}
+}
+
+func TestPublisher_RequestAll(t *testing.T) {
+	topic, _ := NewTopic("TestPublisher_RequestAll", TopicConfig{AllowAllPublishers: true})
+	for i := 0; i < 3; i++ {
+		s, _ := NewSubscriber(fmt.Sprintf("responder %d", i), nil, []*Topic{topic})
+		name := s.Name()
+		_ = s.Respond("any", func(ctx context.Context, msg interface{}) (interface{}, error) {
+			return name, nil
+		})
+		s.Listen()
+	}
+	p := NewPublisher("TestPublisher_RequestAll requester")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	replies, err := p.RequestAll(ctx, topic, "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= time.Second {
+		t.Errorf("RequestAll() waited for the deadline although all subscribers replied")
+	}
+	var got []string
+	for _, r := range replies {
+		got = append(got, r.Payload.(string))
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != "[responder 0 responder 1 responder 2]" {
+		t.Errorf("RequestAll() replies = %v", got)
+	}
+
+	// A subscriber that does not respond makes RequestAll wait for the deadline.
+	silent, _ := NewSubscriber("silent", nil, []*Topic{topic})
+	var h HandlerFunc = func(msg interface{}) error { return nil }
+	_ = silent.AddHandler("any", &h)
+	silent.Listen()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	replies, err = p.RequestAll(ctx, topic, "ping")
+	if err != nil || len(replies) != 3 {
+		t.Errorf("RequestAll() = %d replies, error %v, want 3 replies", len(replies), err)
+	}
+}
+
+func TestPublisher_RequestAllGroup(t *testing.T) {
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, _ := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true})
+	for _, cfg := range []struct{ name, group string }{{"a", "g"}, {"b", "g"}, {"c", "h"}, {"plain", ""}} {
+		s, _ := tm.NewSubscriberWithConfig(cfg.name, SubscriberConfig{Group: cfg.group}, nil, []*Topic{topic})
+		_ = s.Respond("any", func(ctx context.Context, msg interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		s.Listen()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	replies, err := tm.NewPublisher("p").RequestAll(ctx, topic, "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= time.Second {
+		t.Errorf("RequestAll() waited for the deadline although every group replied")
+	}
+	if len(replies) != 3 {
+		t.Errorf("RequestAll() = %d replies, want 3", len(replies))
+	}
+}
+
+func TestPublisher_RequestPattern(t *testing.T) {
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, _ := tm.NewTopic("orders", TopicConfig{AllowAllPublishers: true})
+	s, _ := tm.NewSubscriber("responder", nil, []*Topic{topic})
+	if err := s.Respond(0, func(ctx context.Context, msg interface{}) (interface{}, error) {
+		return msg.(int) * 2, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	// A slow wildcard subscriber with an unbuffered queue.
+	var slow HandlerFunc = func(msg interface{}) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	all, _ := tm.NewSubscriber("all", Handlers{"any": &slow}, nil)
+	if err := all.SubPattern(">"); err != nil {
+		t.Fatal(err)
+	}
+	all.Listen()
+
+	p := tm.NewPublisher("requester")
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		reply, err := p.Request(ctx, topic, 21)
+		cancel()
+		if err != nil || reply != 42 {
+			t.Fatalf("Request() = %v, %v, want 42", reply, err)
+		}
+	}
+	for n := range all.GetSubscriptions() {
+		if n != "orders" {
+			t.Errorf("wildcard subscriber subscribed to %s", n)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff returns how long to wait before a retry.
+type Backoff interface {
+	// Delay returns the wait before the retry following attempt, 1 being
+	// the first call of the handler.
+	Delay(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same time before every retry.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff waits Initial before the first retry and Multiplier
+// times longer before every following one, up to Max.
+type ExponentialBackoff struct {
+	Initial time.Duration
+	// Max caps the wait. 0 means no cap.
+	Max time.Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Jitter is the fraction of the wait, between 0 and 1, that is randomly
+	// taken off, so subscribers failing together do not retry together.
+	Jitter float64
+}
+
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	m := b.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	d := float64(b.Initial) * math.Pow(m, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryableError can be implemented by handler errors to decide themselves
+// whether the handler is called again.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// permanentError is an error that is never retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Retryable() bool { return false }
+
+// Permanent wraps err so that the handler returning it is not retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// RetryPolicy decides how often a failing handler is called for the same message.
+// Messages still failing after the last attempt are sent to the dead-letter Topic.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the handler is called, including
+	// the first call. 0 and 1 mean the handler is not retried.
+	MaxAttempts int
+	// Backoff is the wait between attempts. nil retries immediately.
+	Backoff Backoff
+	// RetryOn lists the errors that are retried, matched with errors.Is.
+	// When empty, all errors are retried. Errors implementing RetryableError
+	// decide themselves, regardless of RetryOn.
+	RetryOn []error
+}
+
+// retryable reports whether the handler is called again after returning err.
+func (p RetryPolicy) retryable(err error) bool {
+	var re RetryableError
+	if errors.As(err, &re) {
+		return re.Retryable()
+	}
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, v := range p.RetryOn {
+		if errors.Is(err, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// do calls fn until it succeeds, returns an error that is not retryable or
+// MaxAttempts is reached. Waiting for the next attempt stops when abort is closed.
+func (p RetryPolicy) do(abort <-chan struct{}, fn func() error) (attempts int, err error) {
+	for {
+		attempts++
+		if err = fn(); err == nil || attempts >= p.MaxAttempts || !p.retryable(err) {
+			return
+		}
+		var delay time.Duration
+		if p.Backoff != nil {
+			delay = p.Backoff.Delay(attempts)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-abort:
+			timer.Stop()
+			err = fmt.Errorf("retry aborted after %d attempts: %w", attempts, err)
+			return
+		}
+	}
+}
+
+// SetRetryPolicy sets the RetryPolicy of the handler registered for the type
+// of typeOf, overriding SubscriberConfig.Retry for its messages.
+// See AddHandler for the accepted values of typeOf.
+func (s *Subscriber) SetRetryPolicy(typeOf interface{}, policy RetryPolicy) (err error) {
+	if typeOf == nil {
+		return fmt.Errorf("Required: typeOf for Subscriber %s", s.Name())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.handlers.setRetry(typeOf, policy) {
+		err = fmt.Errorf("subscriber %s has no handler for type %v", s.name, typeOf)
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+type retryableErr bool
+
+func (e retryableErr) Error() string   { return "retryable error" }
+func (e retryableErr) Retryable() bool { return bool(e) }
+
+func TestExponentialBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff ExponentialBackoff
+		attempt int
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{name: "First retry", backoff: ExponentialBackoff{Initial: 10 * time.Millisecond},
+			attempt: 1, wantMin: 10 * time.Millisecond, wantMax: 10 * time.Millisecond},
+		{name: "Default multiplier", backoff: ExponentialBackoff{Initial: 10 * time.Millisecond},
+			attempt: 3, wantMin: 40 * time.Millisecond, wantMax: 40 * time.Millisecond},
+		{name: "Multiplier", backoff: ExponentialBackoff{Initial: 10 * time.Millisecond, Multiplier: 3},
+			attempt: 3, wantMin: 90 * time.Millisecond, wantMax: 90 * time.Millisecond},
+		{name: "Max", backoff: ExponentialBackoff{Initial: 10 * time.Millisecond, Max: 25 * time.Millisecond},
+			attempt: 5, wantMin: 25 * time.Millisecond, wantMax: 25 * time.Millisecond},
+		{name: "Jitter", backoff: ExponentialBackoff{Initial: 100 * time.Millisecond, Jitter: 0.5},
+			attempt: 1, wantMin: 50 * time.Millisecond, wantMax: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				if got := tt.backoff.Delay(tt.attempt); got < tt.wantMin || got > tt.wantMax {
+					t.Fatalf("Delay() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_do(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "No retry", policy: RetryPolicy{},
+			errs: []error{errTransient, nil}, wantAttempts: 1, wantErr: true},
+		{name: "Succeeds after retry", policy: RetryPolicy{MaxAttempts: 3},
+			errs: []error{errTransient, errTransient, nil}, wantAttempts: 3, wantErr: false},
+		{name: "Gives up after MaxAttempts", policy: RetryPolicy{MaxAttempts: 2, Backoff: ConstantBackoff(time.Millisecond)},
+			errs: []error{errTransient, errTransient, nil}, wantAttempts: 2, wantErr: true},
+		{name: "RetryOn matches wrapped error", policy: RetryPolicy{MaxAttempts: 3, RetryOn: []error{errTransient}},
+			errs: []error{fmt.Errorf("wrapped: %w", errTransient), nil}, wantAttempts: 2, wantErr: false},
+		{name: "RetryOn does not match", policy: RetryPolicy{MaxAttempts: 3, RetryOn: []error{errTransient}},
+			errs: []error{errors.New("other"), nil}, wantAttempts: 1, wantErr: true},
+		{name: "Permanent", policy: RetryPolicy{MaxAttempts: 3},
+			errs: []error{Permanent(errTransient), nil}, wantAttempts: 1, wantErr: true},
+		{name: "RetryableError overrides RetryOn", policy: RetryPolicy{MaxAttempts: 3, RetryOn: []error{errTransient}},
+			errs: []error{retryableErr(true), nil}, wantAttempts: 2, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			attempts, err := tt.policy.do(nil, func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("do() attempts = %d, calls = %d, want %d", attempts, calls, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_doAbort(t *testing.T) {
+	abort := make(chan struct{})
+	close(abort)
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: ConstantBackoff(time.Hour)}
+	attempts, err := policy.do(abort, func() error { return errTransient })
+	if attempts != 1 || !errors.Is(err, errTransient) {
+		t.Errorf("do() = %d, %v, want 1 attempt and errTransient", attempts, err)
+	}
+}
+
+func TestSubscriber_Retry(t *testing.T) {
+	dlq, _ := TM.SetDeadLetterTopic("TestSubscriber_Retry dlq")
+	defer func() {
+		_ = TM.DeleteTopic(dlq.Name())
+	}()
+	letters := make(chan DeadLetter, 10)
+	var collect HandlerFunc = func(msg interface{}) error {
+		letters <- msg.(DeadLetter)
+		return nil
+	}
+	dlqSub, _ := NewSubscriber("TestSubscriber_Retry dlq", Handlers{"any": &collect}, []*Topic{dlq})
+	dlqSub.Listen()
+	defer dlqSub.Close()
+
+	topic, _ := NewTopic("TestSubscriber_Retry", TopicConfig{AllowAllPublishers: true})
+	cfg := SubscriberConfig{Retry: RetryPolicy{MaxAttempts: 3, Backoff: ConstantBackoff(time.Millisecond)}}
+	s, _ := NewSubscriberWithConfig("TestSubscriber_Retry", cfg, nil, []*Topic{topic})
+	var stringCalls, intCalls atomic.Int32
+	var failing HandlerFunc = func(msg interface{}) error {
+		stringCalls.Add(1)
+		return errTransient
+	}
+	var flaky HandlerFunc = func(msg interface{}) error {
+		if intCalls.Add(1) < 5 {
+			return errTransient
+		}
+		return nil
+	}
+	_ = s.AddHandler("", &failing)
+	_ = s.AddHandler(0, &flaky)
+	if err := s.SetRetryPolicy(0, RetryPolicy{MaxAttempts: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRetryPolicy(0.1, RetryPolicy{}); err == nil {
+		t.Errorf("SetRetryPolicy() for type without handler error = nil")
+	}
+	s.Listen()
+	defer s.Close()
+
+	p := NewPublisher("TestSubscriber_Retry")
+	_ = p.Pub(topic, "fail")
+	_ = p.Pub(topic, 1)
+
+	select {
+	case dl := <-letters:
+		if dl.Attempts != 3 || !errors.Is(dl.Err, errTransient) || dl.Envelope.Payload != "fail" {
+			t.Errorf("DeadLetter = %+v, want 3 attempts for fail", dl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no dead letter received")
+	}
+	_ = s.Close()
+	if got := stringCalls.Load(); got != 3 {
+		t.Errorf("subscriber policy called handler %d times, want 3", got)
+	}
+	if got := intCalls.Load(); got != 5 {
+		t.Errorf("handler policy called handler %d times, want 5", got)
+	}
+	select {
+	case dl := <-letters:
+		t.Errorf("unexpected dead letter %+v", dl)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,51 +1,177 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"reflect"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type SubscriberIF interface {
 	Listen()
+	ListenCtx(ctx context.Context)
+	Close() error
 	Name() string
 	AddHandler(interface{}, *HandlerFunc) error
+	AddHandlerCtx(interface{}, *HandlerCtxFunc) error
 	Sub(topicName *Topic) error
+	Unsub(topic *Topic) error
 	Channel() chan interface{}
 	GetSubscriptions() []*Topic
 }
 
 type HandlerFunc func(msg interface{}) (err error)
 
+// HandlerCtxFunc is a handler that receives the context the message was
+// published with. The context carries the publisher's values but is never
+// cancelled by the publisher. The message's Envelope is available through
+// EnvelopeFromContext.
+type HandlerCtxFunc func(ctx context.Context, msg interface{}) (err error)
+
 type HandleType string
 
+// Handlers maps the TypeKey of a type, or "any", to its handler. Use TypeKey
+// for the keys, so same-named types of different packages do not collide.
+// A key that is the name of the type without its package path is deprecated:
+// it matches when no handler is registered under the TypeKey, but only the
+// first type of that name it matched.
 type Handlers map[string]*HandlerFunc
 
 type Subscriptions map[TopicName]*Topic
 
+// SubscriberConfig configures a Subscriber.
+type SubscriberConfig struct {
+	// RemovalPolicy decides whether messages already queued from a Topic
+	// are still handled after the Subscriber lost that Topic.
+	RemovalPolicy RemovalPolicy
+	// QueueSize is the number of messages that can be queued for the
+	// Subscriber before the Overflow policy applies. 0 means unbuffered.
+	QueueSize int
+	// Overflow decides what happens to messages delivered to a full queue.
+	Overflow OverflowPolicy
+	// OverflowTimeout is how long BlockWithTimeout waits for room in the
+	// queue. It must be positive with BlockWithTimeout.
+	OverflowTimeout time.Duration
+	// Retry decides how often a failing handler is called for the same
+	// message, unless the handler has its own policy, see SetRetryPolicy.
+	Retry RetryPolicy
+	// PanicPolicy decides what happens when a handler panics.
+	PanicPolicy PanicPolicy
+	// Workers is the number of messages handled concurrently.
+	// 0 and 1 handle one message at a time in the order they were queued.
+	Workers int
+	// KeyOrdered keeps messages with the same Envelope.Key in order when
+	// Workers is more than 1, while messages with other keys run in parallel.
+	KeyOrdered bool
+	// Logger overrides the Logger of the TopicManager for the Subscriber.
+	Logger Logger
+	// AckMode decides when a message is done with, see AckMode. Unless it
+	// is AutoAck, messages are redelivered until they are acked. Nacked
+	// messages wait for the Backoff of Retry before they are redelivered,
+	// or for DefaultRedeliveryBackoff if Retry has none.
+	AckMode AckMode
+	// VisibilityTimeout is how long a message may go unacked before it is
+	// redelivered, DefaultVisibilityTimeout by default.
+	VisibilityTimeout time.Duration
+	// MaxDeliveries dead-letters a message after it was delivered this many
+	// times without being acked. 0 redelivers it forever.
+	MaxDeliveries int
+	// Start is where the Subscriber starts in the log of the durable Topics
+	// it subscribes to, Resume by default.
+	Start StartPosition
+	// Group makes the Subscriber a member of a consumer group: on every
+	// Topic, each message is delivered to only one member of the group,
+	// chosen by GroupStrategy. All members must use the same GroupStrategy.
+	Group         string
+	GroupStrategy GroupStrategy
+}
+
+// Subscriber is safe for concurrent use.
 type Subscriber struct {
+	mu            sync.RWMutex
 	name          string
 	listening     bool
 	ch            chan interface{}
-	handlers      Handlers
+	handlers      handlerRegistry
 	subscriptions Subscriptions
+	cfg           SubscriberConfig
+	onRemoval     RemovalFunc
+	replyPub      *Publisher
+	middleware    []HandlerMiddleware
+	tm            *TopicManager
+
+	stopped         chan struct{}
+	closing         chan struct{}
+	closeOnce       sync.Once
+	abort           chan struct{}
+	abortOnce       sync.Once
+	shutdownDropped atomic.Int64
+	dropped         atomic.Uint64
+	panics          atomic.Uint64
+	// busy is the number of messages taken from the queue and not handled yet.
+	busy atomic.Int64
 }
 
+// NewSubscriber creates a Subscriber of the default TopicManager TM.
 func NewSubscriber(name string, handlers Handlers, subscriptions []*Topic) (s *Subscriber, err error) {
+	return TM.NewSubscriberWithConfig(name, SubscriberConfig{}, handlers, subscriptions)
+}
+
+// NewSubscriberWithConfig creates a Subscriber of the default TopicManager TM.
+func NewSubscriberWithConfig(name string, cfg SubscriberConfig, handlers Handlers, subscriptions []*Topic) (s *Subscriber, err error) {
+	return TM.NewSubscriberWithConfig(name, cfg, handlers, subscriptions)
+}
+
+// NewSubscriber creates a Subscriber of the TopicManager.
+func (tm *TopicManager) NewSubscriber(name string, handlers Handlers, subscriptions []*Topic) (s *Subscriber, err error) {
+	return tm.NewSubscriberWithConfig(name, SubscriberConfig{}, handlers, subscriptions)
+}
+
+// NewSubscriberWithConfig creates a Subscriber of the TopicManager. It can only
+// subscribe to Topics of the same TopicManager. Without queue settings in cfg,
+// the Subscriber uses the TopicConfig.Queue of its first subscription.
+func (tm *TopicManager) NewSubscriberWithConfig(name string, cfg SubscriberConfig, handlers Handlers, subscriptions []*Topic) (s *Subscriber, err error) {
+	if cfg.QueueSize == 0 && cfg.Overflow == Block && cfg.OverflowTimeout == 0 && len(subscriptions) > 0 && subscriptions[0] != nil {
+		if q := subscriptions[0].queue(); q != nil {
+			cfg.QueueSize, cfg.Overflow, cfg.OverflowTimeout = q.Size, q.Overflow, q.OverflowTimeout
+		}
+	}
+	if cfg.QueueSize < 0 {
+		return nil, fmt.Errorf("QueueSize of Subscriber %s must not be negative", name)
+	}
+	if cfg.Workers < 0 {
+		return nil, fmt.Errorf("Workers of Subscriber %s must not be negative", name)
+	}
+	if cfg.Overflow == BlockWithTimeout && cfg.OverflowTimeout <= 0 {
+		return nil, fmt.Errorf("OverflowTimeout of Subscriber %s must be positive with BlockWithTimeout", name)
+	}
+	if cfg.MaxDeliveries < 0 {
+		return nil, fmt.Errorf("MaxDeliveries of Subscriber %s must not be negative", name)
+	}
 	s = &Subscriber{
 		name:          name,
 		listening:     false,
-		ch:            make(chan interface{}, 0),
-		handlers:      make(Handlers, 0),
+		ch:            make(chan interface{}, cfg.QueueSize),
 		subscriptions: make(Subscriptions, 0),
+		cfg:           cfg,
+		tm:            tm,
+		closing:       make(chan struct{}),
+		abort:         make(chan struct{}),
 	}
 	for k, v := range handlers {
-		_ = s.AddHandler(k, v)
+		if v != nil {
+			if isBareName(k) {
+				s.log().Warn("handler key without package path is deprecated, use TypeKey", "subscriber", name, "key", k)
+			}
+			s.handlers.addNamed(k, handlerEntry{fn: v})
+		}
 	}
 	if subscriptions != nil {
 		for _, v := range subscriptions {
-			s.subscriptions[v.Name()] = v
 			err = v.AddSub(s)
 			if s == nil {
 				return s, fmt.Errorf("cannot subscribe Subscriber %v to Topic %v", s.name, v.Name())
@@ -60,47 +186,352 @@ func NewSubscriber(name string, handlers Handlers, subscriptions []*Topic) (s *S
 	return s, err
 }
 
+// Listen starts handling messages in a new goroutine until the Subscriber is closed.
 func (s *Subscriber) Listen() {
-	if !s.listening {
+	s.ListenCtx(context.Background())
+}
+
+// ListenCtx starts handling messages in a new goroutine until ctx is done or
+// the Subscriber is closed. Messages queued when ctx is done stay queued,
+// so Listen can be called again later.
+func (s *Subscriber) ListenCtx(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.listening && !s.isClosed() {
 		s.listening = true
-		go func() {
-			for msg := range s.ch {
-				log.Debugf("Received message of type %T", msg)
-				handler, ok := s.handlers[reflect.TypeOf(msg).Name()]
-				if !ok {
-					log.Debugf("Subscriber %s has no handler for message type %T, checking existence of handler for \"any\" type.", s.name, msg)
-					handler, ok = s.handlers["any"]
-					if !ok {
-						log.Errorf("Subscriber %s has no handler for message type %T, and no handler for \"any\" type.", s.name, msg)
-						return
-					}
-				}
-				if err := (*handler)(msg); err != nil {
-					log.Errorf("error handling message: %v of type %T on handler: %s: %s", msg, msg, s.Name(), err)
-				}
+		s.stopped = make(chan struct{})
+		go s.listen(ctx, s.stopped)
+	}
+}
+
+func (s *Subscriber) listen(ctx context.Context, stopped chan struct{}) {
+	restart := false
+	defer func() {
+		if restart {
+			s.log().Warn("restarting listener after a handler panic", "subscriber", s.name)
+			go s.listen(ctx, stopped)
+			return
+		}
+		s.mu.Lock()
+		s.listening = false
+		s.mu.Unlock()
+		close(stopped)
+	}()
+	process := s.handle
+	if s.cfg.Workers > 1 {
+		pool := s.startWorkers()
+		defer pool.stop()
+		process = pool.dispatch
+	}
+	for {
+		select {
+		case msg := <-s.ch:
+			s.busy.Add(1)
+			if process(msg) && s.cfg.PanicPolicy == RestartOnPanic {
+				restart = true
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			s.drain(process)
+			return
+		}
+	}
+}
+
+// drain passes the queued messages to process after the Subscriber is closed,
+// or drops them once the shutdown deadline has passed.
+func (s *Subscriber) drain(process func(msg interface{}) bool) {
+	for {
+		select {
+		case msg := <-s.ch:
+			select {
+			case <-s.abort:
+				s.shutdownDropped.Add(1)
+				s.manager().metrics().Dropped(s.name, topicOf(msg))
+				continue
+			default:
 			}
-		}()
+			s.busy.Add(1)
+			process(msg)
+		default:
+			return
+		}
+	}
+}
+
+// handle passes msg to its handler, retrying according to the RetryPolicy.
+// Messages without a handler, or whose handler still fails after the
+// last attempt, are sent to the dead-letter Topic. Unless the AckMode is
+// AutoAck, failed messages are nacked and redelivered instead.
+// It reports whether the handler panicked.
+func (s *Subscriber) handle(msg interface{}) (panicked bool) {
+	defer s.busy.Add(-1)
+	m := s.manager().metrics()
+	m.QueueDepth(s.name, len(s.ch))
+	ctx := context.Background()
+	env, ok := msg.(*Envelope)
+	if ok {
+		if env.topic != nil && s.cfg.RemovalPolicy == DropOnRemoval && !s.subscribed(env.topic) {
+			s.log().Debug("dropped message from removed topic", "subscriber", s.name, "topic", env.Topic, "message_id", env.ID, "type", fmt.Sprintf("%T", env.Payload))
+			m.Dropped(s.name, env.Topic)
+			s.acknowledge(env)
+			return
+		}
+		ctx, msg = context.WithValue(env.ctx, envelopeKey{}, env), env.Payload
+	} else {
+		env = &Envelope{ID: newID(), Time: time.Now(), Headers: make(map[string]string), Payload: msg}
+	}
+	// lookup may bind a handler key without package path to the type of msg.
+	s.mu.Lock()
+	handler, arg, ok := s.handlers.lookup(msg)
+	s.mu.Unlock()
+	if !ok {
+		err := fmt.Errorf("subscriber %s has no handler for message type %T, and no handler for \"any\" type: %w", s.name, msg, ErrNoHandler)
+		s.manager().deadLetter(s, DeadLetter{Envelope: env, Err: err, Subscriber: s.name})
+		s.acknowledge(env)
+		return
+	}
+	policy := s.cfg.Retry
+	if handler.retry != nil {
+		policy = *handler.retry
+	}
+	var a *acker
+	if s.cfg.AckMode != AutoAck && env.topic != nil {
+		a = s.track(env)
+	}
+	fn := s.wrapHandler(handler.call)
+	attempts, err := policy.do(s.abort, func() (err error) {
+		start := time.Now()
+		err = s.call(ctx, fn, arg)
+		m.Handled(s.name, env.Topic, time.Since(start), err)
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			panicked = true
+		}
+		return
+	})
+	switch {
+	case a != nil && err != nil:
+		_ = a.nack(err)
+	case a != nil:
+		if s.cfg.AckMode == AckOnReturn {
+			_ = a.ack()
+		}
+	default:
+		if err != nil {
+			s.manager().deadLetter(s, DeadLetter{Envelope: env, Err: err, Attempts: attempts, Subscriber: s.name})
+		}
+		s.acknowledge(env)
 	}
+	return
 }
 
+// deliver queues msg unless the Subscriber is closed.
+// A full queue is handled according to the Overflow policy.
+func (s *Subscriber) deliver(ctx context.Context, msg interface{}) (err error) {
+	if s.isClosed() {
+		return fmt.Errorf("subscriber %s: %w", s.name, ErrClosed)
+	}
+	switch s.cfg.Overflow {
+	case BlockWithTimeout:
+		timer := time.NewTimer(s.cfg.OverflowTimeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- msg:
+			s.queued(msg)
+		case <-s.closing:
+			err = fmt.Errorf("subscriber %s: %w", s.name, ErrClosed)
+		case <-ctx.Done():
+			err = fmt.Errorf("subscriber %s: %w", s.name, ctx.Err())
+		case <-timer.C:
+			s.drop(msg)
+		}
+	case DropNewest:
+		select {
+		case s.ch <- msg:
+			s.queued(msg)
+		default:
+			s.drop(msg)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- msg:
+				s.queued(msg)
+				return
+			default:
+			}
+			if cap(s.ch) == 0 {
+				s.drop(msg)
+				return
+			}
+			select {
+			case oldest := <-s.ch:
+				s.drop(oldest)
+			default:
+			}
+		}
+	case ErrorOnFull:
+		select {
+		case s.ch <- msg:
+			s.queued(msg)
+		default:
+			s.dropped.Add(1)
+			s.manager().metrics().Dropped(s.name, topicOf(msg))
+			s.acknowledge(msg)
+			err = fmt.Errorf("subscriber %s: %w", s.name, ErrQueueFull)
+		}
+	default:
+		select {
+		case s.ch <- msg:
+			s.queued(msg)
+		case <-s.closing:
+			err = fmt.Errorf("subscriber %s: %w", s.name, ErrClosed)
+		case <-ctx.Done():
+			err = fmt.Errorf("subscriber %s: %w", s.name, ctx.Err())
+		}
+	}
+	return
+}
+
+// queued records the metrics of msg being queued.
+func (s *Subscriber) queued(msg interface{}) {
+	m := s.manager().metrics()
+	m.Delivered(s.name, topicOf(msg))
+	m.QueueDepth(s.name, len(s.ch))
+}
+
+func (s *Subscriber) drop(msg interface{}) {
+	s.dropped.Add(1)
+	s.manager().metrics().Dropped(s.name, topicOf(msg))
+	s.acknowledge(msg)
+	if env, ok := msg.(*Envelope); ok {
+		msg = env.Payload
+	}
+	s.log().Debug("queue is full, dropped message", "subscriber", s.name, "type", fmt.Sprintf("%T", msg))
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// QueueLen returns the number of messages queued for the Subscriber.
+func (s *Subscriber) QueueLen() int {
+	return len(s.ch)
+}
+
+// load returns the number of messages queued for the Subscriber or being
+// handled by it.
+func (s *Subscriber) load() int {
+	return len(s.ch) + int(s.busy.Load())
+}
+
+// Close stops the Subscriber from accepting messages, handles the messages
+// already queued and waits for the listener to finish.
+func (s *Subscriber) Close() (err error) {
+	_, err = s.Shutdown(context.Background())
+	return
+}
+
+// Shutdown stops the Subscriber from accepting messages, lets the listener
+// handle the messages already queued and waits for it to finish, then
+// unsubscribes from all Topics. If ctx is done first, the remaining queued
+// messages are dropped and ctx.Err() is returned. A message whose handler is
+// still running at that point is not waited for. The patterns of the
+// Subscriber are dropped, see SubPattern.
+// dropped is the number of queued messages that were not handled.
+func (s *Subscriber) Shutdown(ctx context.Context) (dropped int, err error) {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	s.mu.RLock()
+	listening, stopped := s.listening, s.stopped
+	s.mu.RUnlock()
+	if listening {
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+			s.abortOnce.Do(func() {
+				close(s.abort)
+			})
+		}
+	}
+	s.dropQueued()
+
+	s.manager().dropPatterns(s)
+	for _, v := range s.GetSubscriptions() {
+		_ = v.RemoveSub(s)
+	}
+	dropped = int(s.shutdownDropped.Swap(0))
+	return
+}
+
+// dropQueued discards the messages still queued for the Subscriber.
+func (s *Subscriber) dropQueued() {
+	for {
+		select {
+		case msg := <-s.ch:
+			s.shutdownDropped.Add(1)
+			s.manager().metrics().Dropped(s.name, topicOf(msg))
+		default:
+			return
+		}
+	}
+}
+
+func (s *Subscriber) isClosed() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddHandler registers handler for messages of the type of typeOf.
+// typeOf can be "any" for all messages without a more specific handler,
+// a reflect.Type, a pointer to an interface type such as (*fmt.Stringer)(nil)
+// for all messages implementing that interface, or a value of the type.
+// A handler for T also handles *T and the other way around, see lookup.
 func (s *Subscriber) AddHandler(typeOf interface{}, handler *HandlerFunc) (err error) {
 
 	if typeOf == nil || handler == nil {
 		err = fmt.Errorf("Required: typeOf and handler. Provided: typeOf: %v", typeOf)
 		return
 	}
-	if typeOf == "any" {
-		s.handlers["any"] = handler
-		log.Debugf("Added handler for type %s, %v for Subscriber %s", "any", runtime.FuncForPC(reflect.ValueOf(*handler).Pointer()).Name(), s.name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt := s.handlers.add(typeOf, handlerEntry{fn: handler})
+	if rt == nil {
+		s.log().Debug("added handler", "subscriber", s.name, "type", "any", "handler", runtime.FuncForPC(reflect.ValueOf(*handler).Pointer()).Name())
 	} else {
-		s.handlers[reflect.TypeOf(typeOf).Name()] = handler
-		log.Debugf("Added handler for type %s, %v for Subscriber %s", reflect.TypeOf(typeOf), &handler, s.name)
+		s.log().Debug("added handler", "subscriber", s.name, "type", rt.String())
 	}
 
 	//TODO: Check if overwriting existing handler
 	return
 }
 
+// AddHandlerCtx registers a handler that receives the context the message
+// was published with. It replaces a HandlerFunc registered for the same type.
+// See AddHandler for the accepted values of typeOf.
+func (s *Subscriber) AddHandlerCtx(typeOf interface{}, handler *HandlerCtxFunc) (err error) {
+	if typeOf == nil || handler == nil {
+		err = fmt.Errorf("Required: typeOf and handler. Provided: typeOf: %v", typeOf)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt := s.handlers.add(typeOf, handlerEntry{ctxFn: handler})
+	s.log().Debug("added context handler", "subscriber", s.name, "type", fmt.Sprint(rt))
+	return
+}
+
 func (s *Subscriber) Name() string {
 	return s.name
 }
@@ -109,12 +540,88 @@ func (s *Subscriber) Channel() chan interface{} {
 	return s.ch
 }
 
+// GetSubscriptions returns a copy of the Topics the Subscriber is subscribed to.
 func (s *Subscriber) GetSubscriptions() Subscriptions {
-	return s.subscriptions
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := make(Subscriptions, len(s.subscriptions))
+	for k, v := range s.subscriptions {
+		subs[k] = v
+	}
+	return subs
 }
 
 func (s *Subscriber) Sub(topic *Topic) (err error) {
-	s.subscriptions[topic.Name()] = topic
 	err = topic.AddSub(s)
 	return err
 }
+
+// SubFrom subscribes the Subscriber to the durable topic, replaying its log
+// from pos.
+func (s *Subscriber) SubFrom(topic *Topic, pos StartPosition) (err error) {
+	return topic.AddSubFrom(s, pos)
+}
+
+// Unsub removes the Subscriber from topic.
+// Messages already queued from topic are handled according to the RemovalPolicy.
+func (s *Subscriber) Unsub(topic *Topic) (err error) {
+	return topic.RemoveSub(s)
+}
+
+// OnRemoval registers fn to be called whenever the Subscriber loses a Topic.
+func (s *Subscriber) OnRemoval(fn RemovalFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRemoval = fn
+}
+
+func (s *Subscriber) subscribed(topic *Topic) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.subscriptions {
+		if v == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscriber) addSubscription(name TopicName, topic *Topic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[name] = topic
+}
+
+// renameSubscription moves topic from old to name after it was renamed.
+func (s *Subscriber) renameSubscription(old, name TopicName, topic *Topic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[old] == topic {
+		delete(s.subscriptions, old)
+		s.subscriptions[name] = topic
+	}
+}
+
+// removeSubscription forgets topic, under whichever name it was subscribed,
+// and notifies the RemovalFunc.
+func (s *Subscriber) removeSubscription(name TopicName, topic *Topic, reason RemovalReason) {
+	s.mu.Lock()
+	for k, v := range s.subscriptions {
+		if v == topic {
+			delete(s.subscriptions, k)
+		}
+	}
+	fn := s.onRemoval
+	s.mu.Unlock()
+	if fn != nil {
+		fn(RemovalEvent{Topic: name, Reason: reason})
+	}
+}
+
+// manager returns the TopicManager the Subscriber belongs to.
+func (s *Subscriber) manager() *TopicManager {
+	if s.tm == nil {
+		return TM
+	}
+	return s.tm
+}
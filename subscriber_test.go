@@ -1,9 +1,14 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var (
@@ -45,8 +50,9 @@ func TestNewSubscriber(t *testing.T) {
 				subscriptions: []*Topic{},
 			}, want: &Subscriber{
 				name:          "Subscriber no Topic",
-				handlers:      Handlers{"string": &fa},
-				subscriptions: []*Topic{},
+				handlers:      handlerRegistry{named: map[string]handlerEntry{"string": {fn: &fa}}},
+				subscriptions: Subscriptions{},
+				tm:            TM,
 			}},
 		{name: "Subscriber One Topic",
 			args: args{
@@ -55,8 +61,9 @@ func TestNewSubscriber(t *testing.T) {
 				subscriptions: []*Topic{stringTopic},
 			}, want: &Subscriber{
 				name:          "Subscriber One Topic",
-				handlers:      Handlers{"string": &fa},
-				subscriptions: []*Topic{stringTopic},
+				handlers:      handlerRegistry{named: map[string]handlerEntry{"string": {fn: &fa}}},
+				subscriptions: Subscriptions{stringTopic.Name(): stringTopic},
+				tm:            TM,
 			}},
 	}
 
@@ -65,6 +72,7 @@ func TestNewSubscriber(t *testing.T) {
 
 			got, _ := NewSubscriber(tt.args.name, tt.args.handlers, tt.args.subscriptions)
 			tt.want.ch = got.ch
+			tt.want.closing, tt.want.abort = got.closing, got.abort
 			equal := reflect.DeepEqual(got, tt.want)
 			if !equal {
 				t.Errorf("Equal: %v,NewSubscriber()\n got: %v\nwant: %v", equal, got, tt.want)
@@ -74,7 +82,7 @@ func TestNewSubscriber(t *testing.T) {
 }
 func TestSubscriber_AddHandler(t *testing.T) {
 
-	s, err := NewSubscriber("Sub1", nil, Subscriptions{})
+	s, err := NewSubscriber("Sub1", nil, nil)
 	if err != nil {
 		log.Fatalf("error creating subscriber: %s", err)
 	}
@@ -116,7 +124,7 @@ func TestSubscriber_Listen(t *testing.T) {
 		name          string
 		listening     bool
 		ch            chan interface{}
-		handlers      Handlers
+		handlers      handlerRegistry
 		subscriptions Subscriptions
 	}
 	tests := []struct {
@@ -144,7 +152,7 @@ func TestSubscriber_Sub(t *testing.T) {
 		name          string
 		listening     bool
 		ch            chan interface{}
-		handlers      Handlers
+		handlers      handlerRegistry
 		subscriptions Subscriptions
 	}
 	type args struct {
@@ -173,3 +181,203 @@ func TestSubscriber_Sub(t *testing.T) {
 		})
 	}
 }
+
+func TestSubscriber_Unsub(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RemovalPolicy
+		wantMsg []interface{}
+	}{
+		{name: "DrainOnRemoval handles queued message", policy: DrainOnRemoval, wantMsg: []interface{}{"first", "queued"}},
+		{name: "DropOnRemoval drops queued message", policy: DropOnRemoval, wantMsg: []interface{}{"first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, _ := NewTopic(TopicName("TestSubscriber_Unsub "+tt.name), TopicConfig{AllowAllPublishers: true})
+			gate := make(chan struct{})
+			var mu sync.Mutex
+			var got []interface{}
+			var h HandlerFunc = func(msg interface{}) error {
+				<-gate
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, msg)
+				return nil
+			}
+			s, _ := NewSubscriberWithConfig("s", SubscriberConfig{RemovalPolicy: tt.policy}, Handlers{"any": &h}, []*Topic{topic})
+			s.Listen()
+
+			// "first" blocks the handler, so "queued" is in flight when unsubscribing.
+			_ = topic.Pub(p1, "first")
+			published := make(chan error)
+			go func() {
+				published <- topic.Pub(p1, "queued")
+			}()
+			time.Sleep(50 * time.Millisecond)
+			if err := s.Unsub(topic); err != nil {
+				t.Fatal(err)
+			}
+			close(gate)
+			if err := <-published; err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(got, tt.wantMsg) {
+				t.Errorf("received %v, want %v", got, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestSubscriber_ListenCtx(t *testing.T) {
+	topic, _ := NewTopic("TestSubscriber_ListenCtx", TopicConfig{AllowAllPublishers: true})
+	received := make(chan interface{}, 1)
+	var h HandlerFunc = func(msg interface{}) error {
+		received <- msg
+		return nil
+	}
+	s, _ := NewSubscriber("s", Handlers{"any": &h}, []*Topic{topic})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ListenCtx(ctx)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+
+	published := make(chan error)
+	go func() {
+		published <- topic.Pub(p1, "after cancel")
+	}()
+	select {
+	case msg := <-received:
+		t.Fatalf("received %v after ListenCtx was cancelled", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Listening again picks up the queued message.
+	s.Listen()
+	if err := <-published; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message not received after listening again")
+	}
+}
+
+func TestSubscriber_Close(t *testing.T) {
+	topic, _ := NewTopic("TestSubscriber_Close", TopicConfig{AllowAllPublishers: true})
+	var handled atomic.Int64
+	var h HandlerFunc = func(msg interface{}) error {
+		time.Sleep(10 * time.Millisecond)
+		handled.Add(1)
+		return nil
+	}
+	s, _ := NewSubscriber("s", Handlers{"any": &h}, []*Topic{topic})
+	s.Listen()
+	_ = topic.Pub(p1, 1, 2, 3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := handled.Load(); got != 3 {
+		t.Errorf("handled %d messages, want 3", got)
+	}
+	if len(topic.Subscribers()) != 0 {
+		t.Errorf("closed Subscriber is still subscribed")
+	}
+	if err := s.deliver(context.Background(), 4); !errors.Is(err, ErrClosed) {
+		t.Errorf("deliver() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestSubscriber_Overflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         SubscriberConfig
+		wantErr     error
+		wantQueued  []interface{}
+		wantDropped uint64
+	}{
+		{name: "DropNewest",
+			cfg:        SubscriberConfig{QueueSize: 2, Overflow: DropNewest},
+			wantQueued: []interface{}{1, 2}, wantDropped: 1},
+		{name: "DropOldest",
+			cfg:        SubscriberConfig{QueueSize: 2, Overflow: DropOldest},
+			wantQueued: []interface{}{2, 3}, wantDropped: 1},
+		{name: "DropOldest unbuffered",
+			cfg:        SubscriberConfig{QueueSize: 0, Overflow: DropOldest},
+			wantQueued: nil, wantDropped: 3},
+		{name: "BlockWithTimeout",
+			cfg:        SubscriberConfig{QueueSize: 2, Overflow: BlockWithTimeout, OverflowTimeout: 10 * time.Millisecond},
+			wantQueued: []interface{}{1, 2}, wantDropped: 1},
+		{name: "ErrorOnFull",
+			cfg:        SubscriberConfig{QueueSize: 2, Overflow: ErrorOnFull},
+			wantErr:    ErrQueueFull,
+			wantQueued: []interface{}{1, 2}, wantDropped: 1},
+		{name: "Block with room in queue",
+			cfg:        SubscriberConfig{QueueSize: 3, Overflow: Block},
+			wantQueued: []interface{}{1, 2, 3}, wantDropped: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, _ := NewTopic(TopicName("TestSubscriber_Overflow "+tt.name), TopicConfig{AllowAllPublishers: true})
+			s, err := NewSubscriberWithConfig("s", tt.cfg, nil, []*Topic{topic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = topic.Pub(p1, 1, 2, 3); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Pub() error = %v, want %v", err, tt.wantErr)
+			}
+			var queued []interface{}
+			for s.QueueLen() > 0 {
+				queued = append(queued, (<-s.ch).(*Envelope).Payload)
+			}
+			if !reflect.DeepEqual(queued, tt.wantQueued) {
+				t.Errorf("queued %v, want %v", queued, tt.wantQueued)
+			}
+			if got := s.Dropped(); got != tt.wantDropped {
+				t.Errorf("Dropped() = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestNewSubscriberWithConfig_OverflowTimeout(t *testing.T) {
+	cfg := SubscriberConfig{Overflow: BlockWithTimeout}
+	if _, err := NewSubscriberWithConfig("TestNewSubscriberWithConfig_OverflowTimeout", cfg, nil, nil); err == nil {
+		t.Errorf("NewSubscriberWithConfig() with BlockWithTimeout and no OverflowTimeout error = nil")
+	}
+}
+
+func TestSubscriber_SlowSubscriberDoesNotBlock(t *testing.T) {
+	topic, _ := NewTopic("TestSubscriber_SlowSubscriberDoesNotBlock", TopicConfig{AllowAllPublishers: true})
+	_, _ = NewSubscriberWithConfig("slow", SubscriberConfig{Overflow: DropNewest}, nil, []*Topic{topic})
+	received := make(chan interface{}, 10)
+	var h HandlerFunc = func(msg interface{}) error {
+		received <- msg
+		return nil
+	}
+	fast, _ := NewSubscriberWithConfig("fast", SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, []*Topic{topic})
+	fast.Listen()
+
+	done := make(chan error)
+	go func() {
+		done <- topic.Pub(p1, 1, 2, 3)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pub() blocked on a subscriber that is not listening")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("fast subscriber received %d messages, want 3", i)
+		}
+	}
+}
@@ -1,23 +1,55 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type TopicName string
 
+// Types maps the TypeKey of a type to the type.
 type Types map[string]reflect.Type
 
 func NewTypes(types ...interface{}) Types {
 	t := make(Types, 0)
 	for _, v := range types {
-		t[reflect.TypeOf(v).Name()] = reflect.TypeOf(v)
+		t[TypeKey(reflect.TypeOf(v))] = reflect.TypeOf(v)
 	}
 	return t
 }
 
+// Allows reports whether msg has one of the registered Types.
+func (ty Types) Allows(msg interface{}) bool {
+	rt := reflect.TypeOf(msg)
+	if rt == nil {
+		return false
+	}
+	allowed, ok := ty[TypeKey(rt)]
+	return ok && allowed == rt
+}
+
+// TypeError is returned when a message is published to a type safe Topic
+// and its type is not one of the Types registered on the Topic.
+type TypeError struct {
+	Topic   TopicName
+	Type    reflect.Type
+	Allowed []reflect.Type
+}
+
+func (e *TypeError) Error() string {
+	allowed := make([]string, 0, len(e.Allowed))
+	for _, v := range e.Allowed {
+		allowed = append(allowed, v.String())
+	}
+	return fmt.Sprintf("type %v is not allowed on type safe topic %s, allowed types: [%s]", e.Type, e.Topic, strings.Join(allowed, ", "))
+}
+
 type Subscribers map[string]*Subscriber
 
 type Publishers map[string]*Publisher
@@ -31,16 +63,47 @@ type TopicConfig struct {
 	AllowOverride      bool
 	AllowAddPub        bool
 	AllowAllPublishers bool
+	// Logger overrides the Logger of the TopicManager for the Topic.
+	Logger Logger
+	// Durable stores the messages in a write-ahead log, see DurableConfig.
+	Durable *DurableConfig
+	// Queue is used by the Subscribers created with this Topic as their
+	// first subscription that have no queue settings of their own.
+	Queue *QueueConfig
 }
 
+// Topic is safe for concurrent use. Publishing only holds a read lock while
+// the subscribers are collected and, for a durable Topic, the messages are
+// logged, so concurrent publishes do not wait for each other.
 type Topic struct {
+	mu          sync.RWMutex
 	name        TopicName
 	subscribers Subscribers
 	publishers  Publishers
 	cfg         TopicConfig
+	deleted     bool
+	closed      bool
+	tm          *TopicManager
+	journal     *topicLog
+	// replaying are the subscribers still catching up with the log. They get
+	// new messages from the log instead of from Pub, to keep them in order.
+	replaying map[string]*Subscriber
+	groups    map[string]*consumerGroup
+	// pubSubscriptions are the Publishers that added the Topic with
+	// Publisher.AddSubscription, whitelisted or not.
+	pubSubscriptions map[*Publisher]struct{}
+
+	interceptors []PublishInterceptor
 }
 
+// NewTopic creates a Topic and registers it in the default TopicManager TM.
 func NewTopic(name TopicName, cfg TopicConfig, pubs ...*Publisher) (topic *Topic, err error) {
+	return TM.NewTopic(name, cfg, pubs...)
+}
+
+// NewTopic creates a Topic and registers it in the TopicManager.
+// Only Subscribers and Publishers of the same TopicManager can use it.
+func (tm *TopicManager) NewTopic(name TopicName, cfg TopicConfig, pubs ...*Publisher) (topic *Topic, err error) {
 
 	if name == "" {
 		err = fmt.Errorf("Cannot create Topic without name.")
@@ -48,6 +111,7 @@ func NewTopic(name TopicName, cfg TopicConfig, pubs ...*Publisher) (topic *Topic
 	}
 
 	t := new(Topic)
+	t.tm = tm
 	t.cfg = cfg
 	t.cfg.Types = make(Types, 0)
 	t.name = name
@@ -66,63 +130,304 @@ func NewTopic(name TopicName, cfg TopicConfig, pubs ...*Publisher) (topic *Topic
 		t.cfg.TypeSafe = true
 		t.cfg.Types = cfg.Types
 	}
-	log.Debugf("Created Topic %v", t)
+	if cfg.Durable != nil {
+		if t.journal, err = openTopicLog(t, *cfg.Durable); err != nil {
+			return nil, fmt.Errorf("cannot open log of topic %s: %w", name, err)
+		}
+	}
+	t.log().Debug("created topic", "topic", name)
 
-	err = TM.RegisterTopic(t)
+	if err = tm.RegisterTopic(t); err != nil && t.journal != nil {
+		_ = t.journal.close()
+	}
 
 	return t, err
 }
 
 func (t *Topic) Pub(pub *Publisher, msg ...interface{}) (err error) {
+	return t.PubCtx(context.Background(), pub, msg...)
+}
+
+// PubCtx publishes msg to all subscribers of the Topic, but to only one
+// member of each consumer group, see SubscriberConfig.Group. Each message is
+// wrapped in an Envelope carrying the headers set with WithHeaders and passed
+// through the PublishInterceptors. Waiting for room in a
+// subscriber queue stops when ctx is done, in which case the remaining
+// subscribers do not receive the messages and ctx.Err() is returned.
+// The values of ctx, but not its cancellation, are passed on to the handlers.
+func (t *Topic) PubCtx(ctx context.Context, pub *Publisher, msg ...interface{}) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if pub.manager() != t.manager() {
+		return fmt.Errorf("publisher %s and topic %s belong to different TopicManagers", pub.Name(), t.Name())
+	}
+	t.mu.RLock()
+	if t.deleted {
+		err = fmt.Errorf("topic %s has been deleted", t.name)
+		t.mu.RUnlock()
+		return
+	}
+	if t.closed {
+		err = fmt.Errorf("topic %s: %w", t.name, ErrClosed)
+		t.mu.RUnlock()
+		return
+	}
 	if _, ok := t.publishers[pub.Name()]; ok == false && t.cfg.AllowAllPublishers == false {
 		err = fmt.Errorf("publisher %s is not whitelisted for topic: \"%s\" and AllowAllPublishers is false", pub.Name(), t.name)
+		t.mu.RUnlock()
 		return
 	}
-	for _, s := range t.subscribers {
-		for _, m := range msg {
-			(s).Channel() <- m
+	interceptors := t.interceptors
+	t.mu.RUnlock()
+
+	envs := make([]*Envelope, 0, len(msg))
+	for _, m := range msg {
+		envs = append(envs, newEnvelope(ctx, t, pub, m))
+	}
+	if envs, err = t.intercept(ctx, pub, interceptors, envs); err != nil {
+		return
+	}
+	payloads := make([]interface{}, 0, len(envs))
+	for _, env := range envs {
+		payloads = append(payloads, env.Payload)
+	}
+
+	t.mu.RLock()
+	if err = t.checkTypes(payloads...); err != nil {
+		t.mu.RUnlock()
+		return
+	}
+	name := t.name
+	skip, _ := ctx.Value(failedKey{}).(*Subscriber)
+	var deliveries []delivery
+	if t.journal != nil {
+		err = t.journal.append(envs, func() { deliveries = t.route(envs, skip) })
+		if err != nil {
+			t.mu.RUnlock()
+			return fmt.Errorf("cannot write to log of topic %s: %w", name, err)
 		}
+	} else {
+		deliveries = t.route(envs, skip)
 	}
+	t.mu.RUnlock()
+
+	m := t.manager().metrics()
+	m.Published(name, len(envs))
+	defer func(start time.Time) {
+		m.PublishBlocked(name, time.Since(start))
+	}(time.Now())
+	var errs []error
+	for _, d := range deliveries {
+		for _, env := range d.envs {
+			if dErr := d.s.deliver(ctx, env.clone()); dErr != nil {
+				if errors.Is(dErr, ErrClosed) {
+					t.log().Debug("skipped closed subscriber", "topic", t.Name(), "subscriber", d.s.Name())
+					break
+				}
+				if ctx.Err() != nil {
+					return errors.Join(append(errs, dErr)...)
+				}
+				errs = append(errs, dErr)
+			}
+		}
+	}
+	err = errors.Join(errs...)
 	return
 }
 
 func (t *Topic) Name() TopicName {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.name
 }
 
+// SetName renames the Topic, also in its TopicManager and in the
+// subscriptions of its Subscribers and Publishers. It fails when the
+// TopicManager has another Topic with that name.
 func (t *Topic) SetName(name TopicName) (err error) {
-	if !t.cfg.AllowSetName {
+	t.mu.RLock()
+	old, allowed := t.name, t.cfg.AllowSetName
+	t.mu.RUnlock()
+	if !allowed {
 		err = fmt.Errorf("allow.SetName is false")
 		return
 	}
 	if name == "" {
-		err = fmt.Errorf("tried to set name to empty string for Topic %s", t.name)
+		err = fmt.Errorf("tried to set name to empty string for Topic %s", old)
+		return
 	}
-	log.Debugf("Changing topic name from %s to %s", t.name, name)
+	if err = t.manager().renameTopic(t, old, name); err != nil {
+		return
+	}
+	t.log().Debug("changing topic name", "topic", old, "name", name)
+	t.mu.Lock()
 	t.name = name
+	subs := make([]*Subscriber, 0, len(t.subscribers))
+	for _, v := range t.subscribers {
+		subs = append(subs, v)
+	}
+	pubs := make([]*Publisher, 0, len(t.pubSubscriptions))
+	for v := range t.pubSubscriptions {
+		pubs = append(pubs, v)
+	}
+	t.mu.Unlock()
+
+	for _, v := range subs {
+		v.renameSubscription(old, name, t)
+	}
+	for _, v := range pubs {
+		v.renameSubscription(old, name, t)
+	}
 	return
 }
 
+// Subscribers returns a copy of the Topic's subscribers.
 func (t *Topic) Subscribers() (s Subscribers) {
-	return t.subscribers
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s = make(Subscribers, len(t.subscribers))
+	for k, v := range t.subscribers {
+		s[k] = v
+	}
+	return
 }
 
+// AddSub subscribes sub to the Topic. A Subscriber replaced because of
+// AllowOverride is unsubscribed. A durable Topic replays its log to sub from
+// the StartPosition of the Subscriber's config.
 func (t *Topic) AddSub(sub *Subscriber) (err error) {
-	if _, ok := t.subscribers[sub.Name()]; ok {
+	return t.addSub(sub, sub.cfg.Start)
+}
+
+// AddSubFrom subscribes sub to the durable Topic, replaying its log from pos.
+func (t *Topic) AddSubFrom(sub *Subscriber, pos StartPosition) (err error) {
+	if t.journal == nil {
+		return fmt.Errorf("cannot subscribe %s from %s: topic %s is not durable", sub.Name(), pos, t.Name())
+	}
+	return t.addSub(sub, pos)
+}
+
+func (t *Topic) addSub(sub *Subscriber, pos StartPosition) (err error) {
+	if sub.manager() != t.manager() {
+		return fmt.Errorf("subscriber %s and topic %s belong to different TopicManagers", sub.Name(), t.Name())
+	}
+	if sub.isClosed() {
+		return fmt.Errorf("cannot add subscriber %s to topic %s: %w", sub.Name(), t.Name(), ErrClosed)
+	}
+	t.mu.Lock()
+	if t.deleted {
+		err = fmt.Errorf("cannot add subscriber %s to deleted topic %s", sub.Name(), t.name)
+		t.mu.Unlock()
+		return
+	}
+	old, ok := t.subscribers[sub.Name()]
+	if ok {
 		if !t.cfg.AllowOverride {
 			err = fmt.Errorf("subscriber %s already exists and allowOverwrite is false", (sub).Name())
+			t.mu.Unlock()
+			return
+		}
+	}
+	first := true
+	if sub.cfg.Group != "" {
+		if first, err = t.joinGroup(sub); err != nil {
+			t.mu.Unlock()
 			return
 		}
 	}
+	var from, to uint64
+	// Members joining a group that already has members do not replay, the
+	// log is replayed to the group only once.
+	if t.journal != nil && first {
+		// Holding the lock, no message is being appended: the ones before to
+		// are replayed, later ones are published to sub.
+		if from, to, err = t.journal.start(sub.consumer(), pos); err != nil {
+			t.mu.Unlock()
+			return fmt.Errorf("cannot subscribe %s to topic %s from %s: %w", sub.Name(), t.name, pos, err)
+		}
+	}
 	t.subscribers[sub.Name()] = sub
+	delete(t.replaying, sub.Name())
+	if from < to {
+		if t.replaying == nil {
+			t.replaying = make(map[string]*Subscriber)
+		}
+		t.replaying[sub.Name()] = sub
+	}
+	name := t.name
+	t.mu.Unlock()
+
+	if ok && old != sub {
+		old.removeSubscription(name, t, Unsubscribed)
+	}
+	sub.addSubscription(name, t)
+	if from < to {
+		go t.replay(sub, from, to)
+	}
+	return
+}
+
+// RemoveSub unsubscribes sub from the Topic and notifies it.
+func (t *Topic) RemoveSub(sub *Subscriber) (err error) {
+	return t.removeSub(sub, Unsubscribed)
+}
+
+func (t *Topic) removeSub(sub *Subscriber, reason RemovalReason) (err error) {
+	t.mu.Lock()
+	if v, ok := t.subscribers[sub.Name()]; !ok || v != sub {
+		err = fmt.Errorf("subscriber %s is not subscribed to topic %s", sub.Name(), t.name)
+		t.mu.Unlock()
+		return
+	}
+	delete(t.subscribers, sub.Name())
+	if t.replaying[sub.Name()] == sub {
+		delete(t.replaying, sub.Name())
+	}
+	name := t.name
+	t.mu.Unlock()
+
+	sub.removeSubscription(name, t, reason)
 	return
 }
 
+// addPublisherSubscription records that pub publishes to the Topic with PubAll.
+func (t *Topic) addPublisherSubscription(pub *Publisher) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.deleted {
+		return fmt.Errorf("cannot add deleted topic %s to publisher %s", t.name, pub.Name())
+	}
+	if t.pubSubscriptions == nil {
+		t.pubSubscriptions = make(map[*Publisher]struct{})
+	}
+	t.pubSubscriptions[pub] = struct{}{}
+	return
+}
+
+func (t *Topic) removePublisherSubscription(pub *Publisher) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pubSubscriptions, pub)
+}
+
+// Publishers returns a copy of the Topic's whitelisted publishers.
 func (t *Topic) Publishers() (p Publishers) {
-	return t.publishers
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	p = make(Publishers, len(t.publishers))
+	for k, v := range t.publishers {
+		p[k] = v
+	}
+	return
 }
 
 func (t *Topic) AddPub(pub *Publisher) (err error) {
+	if pub.manager() != t.manager() {
+		return fmt.Errorf("publisher %s and topic %s belong to different TopicManagers", pub.Name(), t.Name())
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if !t.cfg.AllowAddPub {
 		err = fmt.Errorf("AddPub not allowed for topic %s", t.name)
 		return
@@ -137,11 +442,105 @@ func (t *Topic) AddPub(pub *Publisher) (err error) {
 	return
 }
 
+// RemovePub removes pub from the Topic's whitelisted publishers, removes
+// the Topic from the publisher's subscriptions and notifies the publisher.
+func (t *Topic) RemovePub(pub *Publisher) (err error) {
+	return t.removePub(pub, PublisherRemoved)
+}
+
+func (t *Topic) removePub(pub *Publisher, reason RemovalReason) (err error) {
+	t.mu.Lock()
+	if v, ok := t.publishers[pub.Name()]; !ok || v != pub {
+		err = fmt.Errorf("publisher %s is not a publisher of topic %s", pub.Name(), t.name)
+		t.mu.Unlock()
+		return
+	}
+	delete(t.publishers, pub.Name())
+	delete(t.pubSubscriptions, pub)
+	name := t.name
+	t.mu.Unlock()
+
+	pub.removeSubscription(name, t, reason)
+	return
+}
+
+// close stops the Topic from accepting messages.
+func (t *Topic) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+}
+
+// delete marks the Topic as deleted and removes all its subscribers and publishers.
+func (t *Topic) delete() {
+	t.mu.Lock()
+	t.deleted = true
+	subs := make([]*Subscriber, 0, len(t.subscribers))
+	for _, v := range t.subscribers {
+		subs = append(subs, v)
+	}
+	pubs := make([]*Publisher, 0, len(t.publishers))
+	for _, v := range t.publishers {
+		pubs = append(pubs, v)
+	}
+	// Subscribed Publishers that are not whitelisted are not notified by
+	// removePub.
+	subscribed := make([]*Publisher, 0, len(t.pubSubscriptions))
+	for v := range t.pubSubscriptions {
+		if t.publishers[v.Name()] != v {
+			subscribed = append(subscribed, v)
+		}
+	}
+	t.pubSubscriptions = nil
+	name := t.name
+	t.mu.Unlock()
+
+	for _, v := range subs {
+		_ = t.removeSub(v, TopicDeleted)
+	}
+	for _, v := range pubs {
+		_ = t.removePub(v, TopicDeleted)
+	}
+	for _, v := range subscribed {
+		v.removeSubscription(name, t, TopicDeleted)
+	}
+	t.closeLog()
+}
+
+// Offset returns the offset of the first message the Subscriber named
+// subscriber has not handled in the log of the durable Topic. The offset of
+// a consumer group is kept under "group:" followed by the group's name.
+func (t *Topic) Offset(subscriber string) (offset uint64, ok bool) {
+	if t.journal == nil {
+		return 0, false
+	}
+	return t.journal.offset(subscriber)
+}
+
+// closeLog closes the write-ahead log of a durable Topic.
+func (t *Topic) closeLog() {
+	if t.journal == nil {
+		return
+	}
+	if err := t.journal.close(); err != nil {
+		t.log().Error("cannot close log", "topic", t.Name(), "error", err)
+	}
+}
+
+// Types returns a copy of the Types allowed on the Topic.
 func (t *Topic) Types() (ty Types) {
-	return t.cfg.Types
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ty = make(Types, len(t.cfg.Types))
+	for k, v := range t.cfg.Types {
+		ty[k] = v
+	}
+	return
 }
 
 func (t *Topic) SetTypes(types ...interface{}) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if !t.cfg.AllowSetTypes {
 		err = fmt.Errorf("allow.SetTypes is false for Topic %s", t.name)
 		return
@@ -151,16 +550,55 @@ func (t *Topic) SetTypes(types ...interface{}) (err error) {
 		return
 	}
 	for _, v := range types {
-		t.cfg.Types[reflect.TypeOf(v).Name()] = reflect.TypeOf(v)
+		t.cfg.Types[TypeKey(reflect.TypeOf(v))] = reflect.TypeOf(v)
 	}
 	return
 }
 
+// CheckTypes returns a *TypeError for the first message whose type is not
+// allowed on the Topic. Topics that are not type safe accept every message.
+func (t *Topic) CheckTypes(msg ...interface{}) (err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.checkTypes(msg...)
+}
+
+func (t *Topic) checkTypes(msg ...interface{}) (err error) {
+	if !t.cfg.TypeSafe {
+		return
+	}
+	for _, m := range msg {
+		if !t.cfg.Types.Allows(m) {
+			err = &TypeError{
+				Topic:   t.name,
+				Type:    reflect.TypeOf(m),
+				Allowed: t.allowedTypes(),
+			}
+			return
+		}
+	}
+	return
+}
+
+func (t *Topic) allowedTypes() (allowed []reflect.Type) {
+	for _, v := range t.cfg.Types {
+		allowed = append(allowed, v)
+	}
+	sort.Slice(allowed, func(i, j int) bool {
+		return allowed[i].String() < allowed[j].String()
+	})
+	return
+}
+
 func (t *Topic) IsTypeSafe() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.cfg.TypeSafe
 }
 
 func (t *Topic) SetTypeSafe(typeSafe bool) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if !t.cfg.AllowSetTypeSafe {
 		err = fmt.Errorf("AllowSetTypeSafe is false for Topic %s", t.name)
 		return
@@ -168,3 +606,22 @@ func (t *Topic) SetTypeSafe(typeSafe bool) (err error) {
 	t.cfg.TypeSafe = typeSafe
 	return
 }
+
+// queue returns a copy of the default queue settings of the Topic's Subscribers.
+func (t *Topic) queue() *QueueConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.cfg.Queue == nil {
+		return nil
+	}
+	q := *t.cfg.Queue
+	return &q
+}
+
+// manager returns the TopicManager the Topic belongs to.
+func (t *Topic) manager() *TopicManager {
+	if t.tm == nil {
+		return TM
+	}
+	return t.tm
+}
@@ -1,7 +1,16 @@
 package pubsub
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+)
 
+// TM is the default TopicManager, used by the package-level constructors
+// NewTopic, NewPublisher and NewSubscriber.
 var TM = NewTopicManager()
 
 func init() {
@@ -10,15 +19,46 @@ func init() {
 
 type topics map[TopicName]*Topic
 
+// TopicsManagerConfig configures a TopicManager.
 type TopicsManagerConfig struct {
-	autoCreate bool
+	// AutoCreate lets Topic and GetOrCreate create Topics that do not exist.
+	AutoCreate bool
+	// Templates supply the TopicConfig of auto-created Topics. The first
+	// Template whose Pattern matches the name of the Topic is used.
+	Templates []TopicTemplate
 }
 
+// TopicTemplate is the configuration of the auto-created Topics with a name
+// matching Pattern, see MatchTopic. Config.Queue supplies the queue settings
+// of the Subscribers created for these Topics. With Config.Durable, every
+// Topic keeps its log in a directory named after it within Durable.Dir.
+type TopicTemplate struct {
+	Pattern string
+	Config  TopicConfig
+	// Publishers are whitelisted on the created Topics.
+	Publishers []*Publisher
+}
+
+// TopicManager is safe for concurrent use.
 type TopicManager struct {
+	mu sync.RWMutex
+	// createMu serializes GetOrCreate creating Topics.
+	createMu sync.Mutex
 	topics
 	TopicsManagerConfig
+	closed      bool
+	patterns    []patternSub
+	deadLetters *Topic
+	logger      Logger
+	meter       Metrics
+
+	interceptors     []PublishInterceptor
+	handleMiddleware []HandlerMiddleware
 }
 
+// NewTopicManager creates a TopicManager isolated from TM and all other
+// TopicManagers. Create its Topics, Publishers and Subscribers with
+// tm.NewTopic, tm.NewPublisher and tm.NewSubscriber.
 func NewTopicManager() *TopicManager {
 	t := &TopicManager{
 		topics:              make(topics, 0),
@@ -27,17 +67,113 @@ func NewTopicManager() *TopicManager {
 	return t
 }
 
+// NewTopicManagerWithConfig creates a TopicManager configured with cfg,
+// see NewTopicManager. It returns an error for an invalid Template pattern.
+func NewTopicManagerWithConfig(cfg TopicsManagerConfig) (tm *TopicManager, err error) {
+	for _, v := range cfg.Templates {
+		if err = ValidatePattern(v.Pattern); err != nil {
+			return nil, err
+		}
+	}
+	tm = NewTopicManager()
+	tm.AutoCreate = cfg.AutoCreate
+	tm.Templates = append([]TopicTemplate(nil), cfg.Templates...)
+	return
+}
+
+// SetAutoCreate turns auto-creation of Topics on or off.
+func (tm *TopicManager) SetAutoCreate(autoCreate bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.AutoCreate = autoCreate
+}
+
+// AddTemplate adds a TopicTemplate after the existing ones.
+func (tm *TopicManager) AddTemplate(template TopicTemplate) (err error) {
+	if err = ValidatePattern(template.Pattern); err != nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.Templates = append(tm.Templates, template)
+	return
+}
+
+// Topic returns the Topic with name n, see GetOrCreate. It returns nil when
+// the Topic does not exist and cannot be created.
 func (tm *TopicManager) Topic(n TopicName) (t *Topic) {
-	if tm.autoCreate {
-		t, _ := NewTopic(n, TopicConfig{})
-		tm.topics[n] = t
-	} else {
-		return nil
+	t, _ = tm.GetOrCreate(n)
+	return t
+}
+
+// GetOrCreate returns the Topic with name n. When it does not exist and
+// AutoCreate is on, it is created with the config of the first Template
+// matching n, or with an empty TopicConfig if none matches.
+func (tm *TopicManager) GetOrCreate(n TopicName) (t *Topic, err error) {
+	tm.mu.RLock()
+	t, ok := tm.topics[n]
+	autoCreate := tm.AutoCreate
+	template := TopicTemplate{}
+	for _, v := range tm.Templates {
+		if MatchTopic(v.Pattern, n) {
+			template = v
+			break
+		}
+	}
+	tm.mu.RUnlock()
+	if ok {
+		return t, nil
 	}
-	return tm.topics[n]
+	if !autoCreate {
+		return nil, fmt.Errorf("topic with name %s does not exist and AutoCreate is false", n)
+	}
+
+	// Creating one Topic at a time, a losing caller does not open the log of
+	// a durable Topic another one is creating.
+	tm.createMu.Lock()
+	defer tm.createMu.Unlock()
+	if t, ok = tm.get(n); ok {
+		return t, nil
+	}
+
+	cfg := template.Config
+	if cfg.Durable != nil {
+		// Every Topic gets its own log directory.
+		d := *cfg.Durable
+		d.Dir = filepath.Join(d.Dir, url.PathEscape(string(n)))
+		cfg.Durable = &d
+	}
+	if cfg.Queue != nil {
+		q := *cfg.Queue
+		cfg.Queue = &q
+	}
+	if cfg.Types != nil {
+		cfg.Types = make(Types, len(template.Config.Types))
+		for k, v := range template.Config.Types {
+			cfg.Types[k] = v
+		}
+	}
+	if t, err = tm.NewTopic(n, cfg, template.Publishers...); err != nil {
+		// Another goroutine may have created it first.
+		if existing, ok := tm.get(n); ok {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return
+}
+
+// get returns the registered Topic with name n.
+func (tm *TopicManager) get(n TopicName) (t *Topic, ok bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	t, ok = tm.topics[n]
+	return
 }
 
 func (tm *TopicManager) Topics(topicNames []TopicName) (t []*Topic) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
 	for _, tn := range topicNames {
 		if topic, ok := tm.topics[tn]; ok {
 			t = append(t, topic)
@@ -46,12 +182,120 @@ func (tm *TopicManager) Topics(topicNames []TopicName) (t []*Topic) {
 	return t
 }
 
+// RegisterTopic adds topic to the TopicManager and subscribes the Subscribers
+// with a pattern matching the topic's name, see Subscriber.SubPattern.
 func (tm *TopicManager) RegisterTopic(topic *Topic) (err error) {
-	if _, ok := tm.topics[topic.Name()]; !ok {
-		tm.topics[topic.Name()] = topic
-	} else {
-		err = fmt.Errorf("topic with name %s already exists", topic.Name())
+	name := topic.Name()
+	tm.mu.Lock()
+	if tm.closed {
+		tm.mu.Unlock()
+		return fmt.Errorf("cannot register topic %s: %w", name, ErrClosed)
+	}
+	if _, ok := tm.topics[name]; ok {
+		tm.mu.Unlock()
+		return fmt.Errorf("topic with name %s already exists", name)
 	}
+	tm.topics[name] = topic
+	subs := tm.patternSubscribers(name)
+	tm.mu.Unlock()
 
+	for _, s := range subs {
+		// A Subscriber shutting down drops its patterns right after.
+		if subErr := topic.AddSub(s); subErr != nil && !errors.Is(subErr, ErrClosed) {
+			tm.log().Error("cannot subscribe subscriber to topic matching its pattern", "topic", name, "subscriber", s.Name(), "error", subErr)
+		}
+	}
+	return
+}
+
+// DeleteTopic removes the Topic with name n from the TopicManager.
+// All its subscribers and whitelisted publishers are removed from it and
+// notified, and any further publishing to the Topic fails.
+// renameTopic registers t, registered as old, as name instead. A Topic that
+// is not registered stays so.
+func (tm *TopicManager) renameTopic(t *Topic, old, name TopicName) (err error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.topics[old] != t || old == name {
+		return
+	}
+	if _, ok := tm.topics[name]; ok {
+		return fmt.Errorf("cannot rename topic %s, topic with name %s already exists", old, name)
+	}
+	delete(tm.topics, old)
+	tm.topics[name] = t
+	return
+}
+
+func (tm *TopicManager) DeleteTopic(n TopicName) (err error) {
+	tm.mu.Lock()
+	t, ok := tm.topics[n]
+	if !ok {
+		tm.mu.Unlock()
+		err = fmt.Errorf("topic with name %s does not exist", n)
+		return
+	}
+	delete(tm.topics, n)
+	if tm.deadLetters == t {
+		tm.deadLetters = nil
+	}
+	tm.mu.Unlock()
+
+	t.delete()
+	return
+}
+
+// ShutdownReport lists the number of queued messages each Subscriber
+// dropped during a Shutdown. Subscribers that dropped nothing are omitted.
+type ShutdownReport struct {
+	Dropped map[string]int
+}
+
+// Shutdown stops all Topics from accepting messages and shuts down all their
+// Subscribers concurrently, letting them handle their queued messages until
+// ctx is done. See Subscriber.Shutdown.
+func (tm *TopicManager) Shutdown(ctx context.Context) (report ShutdownReport, err error) {
+	tm.mu.Lock()
+	tm.closed = true
+	ts := make([]*Topic, 0, len(tm.topics))
+	for _, t := range tm.topics {
+		ts = append(ts, t)
+	}
+	tm.mu.Unlock()
+
+	subs := make(map[*Subscriber]struct{})
+	for _, t := range ts {
+		t.close()
+		for _, s := range t.Subscribers() {
+			subs[s] = struct{}{}
+		}
+	}
+
+	report.Dropped = make(map[string]int)
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for s := range subs {
+		wg.Add(1)
+		go func(s *Subscriber) {
+			defer wg.Done()
+			dropped, err := s.Shutdown(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if dropped > 0 {
+				report.Dropped[s.Name()] += dropped
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("subscriber %s: %w", s.Name(), err))
+			}
+		}(s)
+	}
+	wg.Wait()
+	for _, t := range ts {
+		t.closeLog()
+	}
+	err = errors.Join(errs...)
 	return
 }
@@ -1,8 +1,11 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewTopicManager(t *testing.T) {
@@ -152,3 +155,309 @@ func TestTopicManager_Topics(t *testing.T) {
 		})
 	}
 }
+
+func TestTopicManager_DeleteTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  bool
+		wantErr bool
+	}{
+		{name: "Delete existing Topic", create: true, wantErr: false},
+		{name: "Delete non-existing Topic", create: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := TopicName("TestTopicManager_DeleteTopic " + tt.name)
+			p := NewPublisher("p")
+			s, _ := NewSubscriber("s", nil, nil)
+			var events []RemovalEvent
+			p.OnRemoval(func(ev RemovalEvent) { events = append(events, ev) })
+			s.OnRemoval(func(ev RemovalEvent) { events = append(events, ev) })
+			var topic *Topic
+			if tt.create {
+				topic, _ = NewTopic(n, TopicConfig{}, p)
+				_ = s.Sub(topic)
+			}
+			if err := TM.DeleteTopic(n); (err != nil) != tt.wantErr {
+				t.Errorf("DeleteTopic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := TM.Topics([]TopicName{n}); len(got) != 0 {
+				t.Errorf("Topics() = %v, want none", got)
+			}
+			if !tt.create {
+				return
+			}
+			want := []RemovalEvent{{Topic: n, Reason: TopicDeleted}, {Topic: n, Reason: TopicDeleted}}
+			if !reflect.DeepEqual(events, want) {
+				t.Errorf("RemovalEvents = %v, want %v", events, want)
+			}
+			if len(s.GetSubscriptions()) != 0 || len(topic.Subscribers()) != 0 || len(topic.Publishers()) != 0 {
+				t.Errorf("Topic relationships not removed")
+			}
+			if err := topic.Pub(p, "42"); err == nil {
+				t.Errorf("Pub() to deleted Topic error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestTopicManager_DeleteTopicSubscribedPublisher(t *testing.T) {
+	tests := []struct {
+		name        string
+		whitelisted bool
+	}{
+		{name: "Not whitelisted", whitelisted: false},
+		{name: "Whitelisted", whitelisted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			p := tm.NewPublisher("p")
+			var whitelist []*Publisher
+			if tt.whitelisted {
+				whitelist = append(whitelist, p)
+			}
+			a, _ := tm.NewTopic("a", TopicConfig{AllowAllPublishers: true}, whitelist...)
+			b, _ := tm.NewTopic("b", TopicConfig{AllowAllPublishers: true})
+			_ = p.AddSubscription(a)
+			_ = p.AddSubscription(b)
+			var events []RemovalEvent
+			p.OnRemoval(func(ev RemovalEvent) { events = append(events, ev) })
+
+			if err := tm.DeleteTopic("a"); err != nil {
+				t.Fatal(err)
+			}
+			if want := []RemovalEvent{{Topic: "a", Reason: TopicDeleted}}; !reflect.DeepEqual(events, want) {
+				t.Errorf("RemovalEvents = %v, want %v", events, want)
+			}
+			if want := (Subscriptions{"b": b}); !reflect.DeepEqual(p.GetSubscriptions(), want) {
+				t.Errorf("GetSubscriptions() = %v, want %v", p.GetSubscriptions(), want)
+			}
+			if err := p.PubAll("42"); err != nil {
+				t.Errorf("PubAll() error = %v", err)
+			}
+			if err := p.AddSubscription(a); err == nil {
+				t.Error("AddSubscription() of deleted Topic succeeded")
+			}
+		})
+	}
+}
+
+func TestTopicManager_Shutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		block   bool
+		wantErr error
+	}{
+		{name: "Shutdown with idle handlers", timeout: time.Second, block: false, wantErr: nil},
+		{name: "Shutdown with blocked handler", timeout: 50 * time.Millisecond, block: true, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			topic, _ := NewTopic(TopicName("TestTopicManager_Shutdown "+tt.name), TopicConfig{AllowAllPublishers: true})
+			_ = tm.RegisterTopic(topic)
+
+			gate := make(chan struct{})
+			defer close(gate)
+			var h HandlerFunc = func(msg interface{}) error {
+				if tt.block {
+					<-gate
+				}
+				return nil
+			}
+			s, _ := NewSubscriber("s", Handlers{"any": &h}, []*Topic{topic})
+			s.Listen()
+			_ = topic.Pub(p1, "42")
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			_, err := tm.Shutdown(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Shutdown() error = %v, want %v", err, tt.wantErr)
+			}
+			if err = topic.Pub(p1, "42"); !errors.Is(err, ErrClosed) {
+				t.Errorf("Pub() after Shutdown error = %v, want ErrClosed", err)
+			}
+			other, _ := NewTopic(TopicName("TestTopicManager_Shutdown other "+tt.name), TopicConfig{})
+			if err = tm.RegisterTopic(other); !errors.Is(err, ErrClosed) {
+				t.Errorf("RegisterTopic() after Shutdown error = %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+func TestTopicManager_Isolated(t *testing.T) {
+	tmA, tmB := NewTopicManager(), NewTopicManager()
+	topicA, errA := tmA.NewTopic("orders", TopicConfig{AllowAllPublishers: true})
+	topicB, errB := tmB.NewTopic("orders", TopicConfig{AllowAllPublishers: true, AllowAddPub: true})
+	if errA != nil || errB != nil {
+		t.Fatalf("NewTopic() with the same name in two TopicManagers: %v, %v", errA, errB)
+	}
+	if _, ok := TM.get("orders"); ok {
+		t.Errorf("topic of a TopicManager is registered in TM")
+	}
+	intercepted := 0
+	tmA.UsePublish(func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			intercepted++
+			return next(ctx, env)
+		}
+	})
+
+	subA, _ := tmA.NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 1}, nil, []*Topic{topicA})
+	subB, _ := tmB.NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 1}, nil, []*Topic{topicB})
+	pubA, pubB := tmA.NewPublisher("p"), tmB.NewPublisher("p")
+	if err := pubA.Pub(topicA, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubB.Pub(topicB, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := (<-subA.ch).(*Envelope).Payload; got != "a" || subA.QueueLen() != 0 {
+		t.Errorf("subscriber of tmA received %v", got)
+	}
+	if got := (<-subB.ch).(*Envelope).Payload; got != "b" || subB.QueueLen() != 0 {
+		t.Errorf("subscriber of tmB received %v", got)
+	}
+	if intercepted != 1 {
+		t.Errorf("interceptor of tmA ran %d times, want 1", intercepted)
+	}
+	if ok, err := pubA.CheckType("orders", "a"); !ok || err != nil {
+		t.Errorf("CheckType() in own TopicManager = %v, %v", ok, err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{name: "Subscribe to other manager", fn: func() error { return topicB.AddSub(subA) }},
+		{name: "Publish to other manager", fn: func() error { return pubA.Pub(topicB, "a") }},
+		{name: "Whitelist publisher of other manager", fn: func() error { return topicB.AddPub(pubA) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); err == nil {
+				t.Errorf("error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestTopicManager_GetOrCreate(t *testing.T) {
+	tm, err := NewTopicManagerWithConfig(TopicsManagerConfig{
+		AutoCreate: true,
+		Templates:  []TopicTemplate{{Pattern: "orders.>", Config: TopicConfig{Types: NewTypes(0)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Templates[0].Publishers = []*Publisher{tm.NewPublisher("orders")}
+	if err = tm.AddTemplate(TopicTemplate{Pattern: "*.events", Config: TopicConfig{AllowAllPublishers: true}}); err != nil {
+		t.Fatal(err)
+	}
+	existing, _ := tm.NewTopic("existing", TopicConfig{AllowSetName: true})
+
+	tests := []struct {
+		name           string
+		topic          TopicName
+		wantTopic      *Topic
+		wantTypeSafe   bool
+		wantAllowAll   bool
+		wantPublishers int
+	}{
+		{name: "Existing topic is returned", topic: "existing", wantTopic: existing},
+		{name: "First matching template", topic: "orders.eu.events", wantTypeSafe: true, wantPublishers: 1},
+		{name: "Second template", topic: "user.events", wantAllowAll: true},
+		{name: "No matching template", topic: "other", wantPublishers: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tm.GetOrCreate(tt.topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again := tm.Topic(tt.topic); again != got {
+				t.Errorf("Topic() after GetOrCreate() returned another Topic")
+			}
+			if tt.wantTopic != nil && got != tt.wantTopic {
+				t.Errorf("GetOrCreate() = %v, want existing topic", got)
+			}
+			if tt.wantTopic != nil {
+				return
+			}
+			if got.IsTypeSafe() != tt.wantTypeSafe || got.cfg.AllowAllPublishers != tt.wantAllowAll || len(got.Publishers()) != tt.wantPublishers {
+				t.Errorf("GetOrCreate() config = %+v with %d publishers", got.cfg, len(got.Publishers()))
+			}
+		})
+	}
+
+	euTypes, usTypes := tm.Topic("orders.eu.events").cfg.Types, tm.Topic("orders.us").cfg.Types
+	if reflect.ValueOf(euTypes).Pointer() == reflect.ValueOf(usTypes).Pointer() {
+		t.Errorf("topics created from the same template share their Types")
+	}
+
+	tm.SetAutoCreate(false)
+	if got := tm.Topic("not.created"); got != nil {
+		t.Errorf("Topic() without AutoCreate = %v, want nil", got)
+	}
+	if _, err := tm.GetOrCreate("not.created"); err == nil {
+		t.Errorf("GetOrCreate() without AutoCreate error = nil")
+	}
+	if err := tm.AddTemplate(TopicTemplate{Pattern: "a.>.b"}); err == nil {
+		t.Errorf("AddTemplate() with invalid pattern error = nil")
+	}
+	if _, err := NewTopicManagerWithConfig(TopicsManagerConfig{Templates: []TopicTemplate{{Pattern: ""}}}); err == nil {
+		t.Errorf("NewTopicManagerWithConfig() with invalid pattern error = nil")
+	}
+}
+
+func TestTopicManager_GetOrCreateQueue(t *testing.T) {
+	queue := &QueueConfig{Size: 5, Overflow: BlockWithTimeout, OverflowTimeout: time.Second}
+	tm, _ := NewTopicManagerWithConfig(TopicsManagerConfig{
+		AutoCreate: true,
+		Templates:  []TopicTemplate{{Pattern: "jobs.*", Config: TopicConfig{Queue: queue}}},
+	})
+	tests := []struct {
+		name string
+		cfg  SubscriberConfig
+		want QueueConfig
+	}{
+		{name: "Template queue", cfg: SubscriberConfig{}, want: *queue},
+		{name: "Own queue", cfg: SubscriberConfig{QueueSize: 2, Overflow: DropNewest}, want: QueueConfig{Size: 2, Overflow: DropNewest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tm.NewSubscriberWithConfig(tt.name, tt.cfg, nil, []*Topic{tm.Topic("jobs.a")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := QueueConfig{Size: cap(s.ch), Overflow: s.cfg.Overflow, OverflowTimeout: s.cfg.OverflowTimeout}
+			if got != tt.want {
+				t.Errorf("queue = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if tm.Topic("jobs.a").cfg.Queue == queue {
+		t.Error("topic shares the Queue of its template")
+	}
+}
+
+func TestTopicManager_GetOrCreateConcurrent(t *testing.T) {
+	tm, _ := NewTopicManagerWithConfig(TopicsManagerConfig{AutoCreate: true})
+	const n = 8
+	got := make(chan *Topic, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			topic, _ := tm.GetOrCreate("concurrent")
+			got <- topic
+		}()
+	}
+	first := <-got
+	for i := 1; i < n; i++ {
+		if topic := <-got; topic != first || topic == nil {
+			t.Fatalf("GetOrCreate() returned different Topics for the same name")
+		}
+	}
+}
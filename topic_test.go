@@ -1,6 +1,8 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"reflect"
 	"testing"
@@ -14,8 +16,8 @@ func init() {
 var (
 	p1    = NewPublisher("p1")
 	p2    = NewPublisher("p2")
-	s1, _ = NewSubscriber("s1", nil, Subscriptions{})
-	s2, _ = NewSubscriber("s2", nil, Subscriptions{})
+	s1, _ = NewSubscriber("s1", nil, nil)
+	s2, _ = NewSubscriber("s2", nil, nil)
 )
 
 func TestNewTopic(t *testing.T) {
@@ -36,6 +38,7 @@ func TestNewTopic(t *testing.T) {
 		}, wantT: &Topic{
 			name:        "TestNewTopicName",
 			subscribers: make(Subscribers, 0),
+			tm:          TM,
 			publishers:  make(Publishers, 0),
 			cfg: TopicConfig{
 				Types: make(Types, 0),
@@ -47,6 +50,7 @@ func TestNewTopic(t *testing.T) {
 		}, wantT: &Topic{
 			name:        "TestNewTopicNoConfig",
 			subscribers: make(Subscribers, 0),
+			tm:          TM,
 			publishers:  make(Publishers, 0),
 			cfg: TopicConfig{
 				Types: make(Types, 0),
@@ -58,14 +62,17 @@ func TestNewTopic(t *testing.T) {
 		}, wantT: &Topic{
 			name:        "TestNewTopic2Publishers",
 			subscribers: make(Subscribers, 0),
+			tm:          TM,
 			publishers: Publishers{
 				"p1": &Publisher{
 					name:          "p1",
 					subscriptions: Subscriptions{},
+					tm:            TM,
 				},
 				"p2": &Publisher{
 					name:          "p2",
 					subscriptions: Subscriptions{},
+					tm:            TM,
 				}},
 			cfg: TopicConfig{
 				Types: make(Types, 0),
@@ -77,6 +84,7 @@ func TestNewTopic(t *testing.T) {
 		}, wantT: &Topic{
 			name:        "Create Topic with nil publishers",
 			subscribers: make(Subscribers, 0),
+			tm:          TM,
 			publishers:  make(Publishers, 0),
 			cfg: TopicConfig{
 				Types: make(Types, 0),
@@ -93,6 +101,7 @@ func TestNewTopic(t *testing.T) {
 		}, wantT: &Topic{
 			name:        "TestNewTopic2Types",
 			subscribers: make(Subscribers, 0),
+			tm:          TM,
 			publishers:  make(Publishers, 0),
 			cfg: TopicConfig{
 				Types: Types{
@@ -125,18 +134,18 @@ func TestTopic_AddPub(t1 *testing.T) {
 	}
 	tests := []struct {
 		name    string
-		fields  Topic
+		fields  *Topic
 		args    args
 		wantErr bool
 	}{
-		{name: "Topic no Publishers and AllowAddPub true", fields: Topic{
+		{name: "Topic no Publishers and AllowAddPub true", fields: &Topic{
 			name:       "AllowAddPub true",
 			publishers: make(Publishers, 0),
 			cfg: TopicConfig{
 				AllowAddPub: true,
 			},
 		}, args: struct{ pub *Publisher }{pub: p1}, wantErr: false},
-		{name: "Topic no Publishers and AllowAddPub false", fields: Topic{
+		{name: "Topic no Publishers and AllowAddPub false", fields: &Topic{
 			name:       "AllowAddPub false",
 			publishers: make(Publishers, 0),
 			cfg: TopicConfig{
@@ -144,7 +153,7 @@ func TestTopic_AddPub(t1 *testing.T) {
 			},
 		}, args: struct{ pub *Publisher }{pub: p1}, wantErr: true},
 
-		{name: "Topic with Publishers and AllowAddPub true", fields: Topic{
+		{name: "Topic with Publishers and AllowAddPub true", fields: &Topic{
 			name: "AllowAddPub true",
 			publishers: Publishers{
 				"p1": &Publisher{
@@ -154,7 +163,7 @@ func TestTopic_AddPub(t1 *testing.T) {
 				AllowAddPub: true,
 			},
 		}, args: struct{ pub *Publisher }{pub: p2}, wantErr: false},
-		{name: "Topic with Publishers and AllowAddPub false", fields: Topic{
+		{name: "Topic with Publishers and AllowAddPub false", fields: &Topic{
 			name: "AllowAddPub false",
 			publishers: Publishers{
 				"p1": &Publisher{
@@ -164,7 +173,7 @@ func TestTopic_AddPub(t1 *testing.T) {
 				AllowAddPub: false,
 			},
 		}, args: struct{ pub *Publisher }{pub: p1}, wantErr: true},
-		{name: "Add Publisher with same name to topic and allowOverwrite true", fields: Topic{
+		{name: "Add Publisher with same name to topic and allowOverwrite true", fields: &Topic{
 			name: "allowOverwrite false",
 			publishers: Publishers{
 				"p1": &Publisher{
@@ -175,7 +184,7 @@ func TestTopic_AddPub(t1 *testing.T) {
 				AllowOverride: true,
 			},
 		}, args: struct{ pub *Publisher }{pub: p1}, wantErr: false},
-		{name: "Add Publisher with same name to topic and allowOverwrite false", fields: Topic{
+		{name: "Add Publisher with same name to topic and allowOverwrite false", fields: &Topic{
 			name: "allowOverwrite false",
 			publishers: Publishers{
 				"p1": &Publisher{
@@ -210,44 +219,44 @@ func TestTopic_AddSub(t1 *testing.T) {
 	}
 	tests := []struct {
 		name    string
-		topic   Topic
+		topic   *Topic
 		args    args
 		wantErr bool
 	}{
-		{name: "Topic no Subs and AllowOverride true", topic: Topic{
+		{name: "Topic no Subs and AllowOverride true", topic: &Topic{
 			name:        "Topic no Subs and AllowOverride true",
 			subscribers: make(Subscribers, 0),
 			cfg:         TopicConfig{AllowOverride: true},
 		}, args: args{
 			s1,
 		}, wantErr: false},
-		{name: "Topic no Subs and AllowOverride false", topic: Topic{
+		{name: "Topic no Subs and AllowOverride false", topic: &Topic{
 			name:        "Topic no Subs and AllowOverride false",
 			subscribers: make(Subscribers, 0),
 			cfg:         TopicConfig{AllowOverride: false},
 		}, args: args{s1}, wantErr: false},
-		{name: "Add Subscriber with same name to topic AllowOverride true", topic: Topic{
+		{name: "Add Subscriber with same name to topic AllowOverride true", topic: &Topic{
 			name: "Topic same Sub and AllowOverride true",
 			subscribers: Subscribers{
 				"s1": s1,
 			},
 			cfg: TopicConfig{AllowOverride: true},
 		}, args: args{s1}, wantErr: false},
-		{name: "Add Subscriber with same name to topic and AllowOverride false", topic: Topic{
+		{name: "Add Subscriber with same name to topic and AllowOverride false", topic: &Topic{
 			name: "Topic same Sub and AllowOverride false",
 			subscribers: Subscribers{
 				"s1": s1,
 			},
 			cfg: TopicConfig{AllowOverride: false},
 		}, args: args{s1}, wantErr: true},
-		{name: "Add Subscriber with other name to topic AllowOverride true", topic: Topic{
+		{name: "Add Subscriber with other name to topic AllowOverride true", topic: &Topic{
 			name: "Topic same Sub and AllowOverride true",
 			subscribers: Subscribers{
 				"s1": s1,
 			},
 			cfg: TopicConfig{AllowOverride: true},
 		}, args: args{s2}, wantErr: false},
-		{name: "Add Subscriber with other name to topic and AllowOverride false", topic: Topic{
+		{name: "Add Subscriber with other name to topic and AllowOverride false", topic: &Topic{
 			name: "Topic same Sub and AllowOverride false",
 			subscribers: Subscribers{
 				"s1": s1,
@@ -276,10 +285,10 @@ func TestTopic_IsTypeSafe(t1 *testing.T) {
 
 	tests := []struct {
 		name  string
-		topic Topic
+		topic *Topic
 		want  bool
 	}{
-		{name: "Topic isTypeSafe true", topic: Topic{
+		{name: "Topic isTypeSafe true", topic: &Topic{
 			name:        "Topic isTypeSafe true",
 			subscribers: nil,
 			publishers:  nil,
@@ -287,7 +296,7 @@ func TestTopic_IsTypeSafe(t1 *testing.T) {
 				TypeSafe: true,
 			},
 		}, want: true},
-		{name: "Topic isTypeSafe false", topic: Topic{
+		{name: "Topic isTypeSafe false", topic: &Topic{
 			name:        "Topic isTypeSafe false",
 			subscribers: nil,
 			publishers:  nil,
@@ -314,10 +323,10 @@ func TestTopic_IsTypeSafe(t1 *testing.T) {
 func TestTopic_Name(t1 *testing.T) {
 	tests := []struct {
 		name  string
-		topic Topic
+		topic *Topic
 		want  TopicName
 	}{
-		{name: "Topic with name", topic: Topic{
+		{name: "Topic with name", topic: &Topic{
 			name: "Topic with name",
 		}, want: "Topic with name"},
 	}
@@ -339,7 +348,7 @@ func TestTopic_Name(t1 *testing.T) {
 func TestTopic_Pub(t1 *testing.T) {
 
 	type args struct {
-		pub Publisher
+		pub *Publisher
 		msg []interface{}
 	}
 	type handler struct {
@@ -376,7 +385,7 @@ func TestTopic_Pub(t1 *testing.T) {
 					handlefunc: simpleConsoleIntHandler,
 				}},
 			args: args{
-				pub: *p1,
+				pub: p1,
 				msg: []interface{}{"Message: Publisher exists and AllowAllPublishers false", 42},
 			}, wantErr: false},
 		{name: "Publisher exists, AllowAllPublishers false, 2 messages sent", topic: tp{
@@ -396,7 +405,7 @@ func TestTopic_Pub(t1 *testing.T) {
 					handlefunc: simpleConsoleIntHandler,
 				}},
 			args: args{
-				pub: *p1,
+				pub: p1,
 				msg: []interface{}{"Message: Publisher exists and AllowAllPublishers false", 42},
 			}, wantErr: false},
 		{
@@ -418,7 +427,7 @@ func TestTopic_Pub(t1 *testing.T) {
 					handlefunc: simpleConsoleIntHandler,
 				}},
 			args: args{
-				pub: *p1,
+				pub: p1,
 				msg: []interface{}{"Message: Publisher exists and AllowAllPublishers true", 42},
 			}, wantErr: true},
 		{
@@ -444,7 +453,7 @@ func TestTopic_Pub(t1 *testing.T) {
 					handlefunc: simpleConsoleAnyHandler,
 				}},
 			args: args{
-				pub: *p1,
+				pub: p1,
 				msg: []interface{}{"Message: Publisher exists and AllowAllPublishers true", 42, 0.2},
 			}, wantErr: false,
 		},
@@ -458,7 +467,7 @@ func TestTopic_Pub(t1 *testing.T) {
 				log.Error(err)
 			}
 
-			s3, err := NewSubscriber("s3", nil, Subscriptions{})
+			s3, err := NewSubscriber("s3", nil, nil)
 			err = t.AddSub(s3)
 			if err != nil {
 				log.Error(err)
@@ -468,7 +477,7 @@ func TestTopic_Pub(t1 *testing.T) {
 			}
 			log.Debugf("Handlers registered: %v", s3.handlers)
 			s3.Listen()
-			err = t.Pub(&tt.args.pub, tt.args.msg...)
+			err = t.Pub(tt.args.pub, tt.args.msg...)
 			log.Error(err)
 			if err != nil != tt.wantErr {
 				t1.Errorf("Pub() error = %v, wantErr %v", err, tt.wantErr)
@@ -478,6 +487,77 @@ func TestTopic_Pub(t1 *testing.T) {
 	}
 }
 
+func TestTopic_PubTypeSafe(t1 *testing.T) {
+	type args struct {
+		msg []interface{}
+	}
+	tests := []struct {
+		name      string
+		topic     TopicName
+		cfg       TopicConfig
+		args      args
+		wantErr   bool
+		wantType  reflect.Type
+		delivered int
+	}{
+		{name: "TypeSafe, all types allowed",
+			topic: "TypeSafe, all types allowed",
+			cfg: TopicConfig{
+				Types:              NewTypes("", 42),
+				AllowAllPublishers: true,
+			}, args: args{msg: []interface{}{"42", 42}}, wantErr: false, delivered: 2},
+		{name: "TypeSafe, one type not allowed rejects batch",
+			topic: "TypeSafe, one type not allowed rejects batch",
+			cfg: TopicConfig{
+				Types:              NewTypes(""),
+				AllowAllPublishers: true,
+			}, args: args{msg: []interface{}{"42", 42}}, wantErr: true, wantType: reflect.TypeOf(42), delivered: 0},
+		{name: "TypeSafe, nil message not allowed",
+			topic: "TypeSafe, nil message not allowed",
+			cfg: TopicConfig{
+				Types:              NewTypes(""),
+				AllowAllPublishers: true,
+			}, args: args{msg: []interface{}{nil}}, wantErr: true, delivered: 0},
+		{name: "Not TypeSafe, every type allowed",
+			topic: "Not TypeSafe, every type allowed",
+			cfg: TopicConfig{
+				AllowAllPublishers: true,
+			}, args: args{msg: []interface{}{"42", 42, 0.2}}, wantErr: false, delivered: 3},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			t, err := NewTopic(tt.topic, tt.cfg)
+			if err != nil {
+				t1.Fatal(err)
+			}
+			s, _ := NewSubscriber("s", nil, []*Topic{t})
+			received := make(chan interface{}, len(tt.args.msg))
+			var h HandlerFunc = func(msg interface{}) error {
+				received <- msg
+				return nil
+			}
+			_ = s.AddHandler("any", &h)
+			s.Listen()
+
+			err = t.Pub(p1, tt.args.msg...)
+			if (err != nil) != tt.wantErr {
+				t1.Errorf("Pub() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var typeErr *TypeError
+			if tt.wantErr && !errors.As(err, &typeErr) {
+				t1.Errorf("Pub() error = %v, want *TypeError", err)
+			}
+			if tt.wantType != nil && typeErr.Type != tt.wantType {
+				t1.Errorf("TypeError.Type = %v, want %v", typeErr.Type, tt.wantType)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := len(received); got != tt.delivered {
+				t1.Errorf("delivered %d messages, want %d", got, tt.delivered)
+			}
+		})
+	}
+}
+
 func TestTopic_Publishers(t1 *testing.T) {
 	type tp struct {
 		name        TopicName
@@ -589,25 +669,70 @@ func TestTopic_SetName(t1 *testing.T) {
 	}
 }
 
+func TestTopic_SetNameRegistered(t *testing.T) {
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, _ := tm.NewTopic("old", TopicConfig{AllowSetName: true})
+	_, _ = tm.NewTopic("taken", TopicConfig{})
+	s, _ := tm.NewSubscriber("s", nil, []*Topic{topic})
+	p := tm.NewPublisher("p")
+	if err := p.AddSubscription(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := topic.SetName("taken"); err == nil {
+		t.Error("SetName() to the name of another topic error = nil")
+	}
+	if err := topic.SetName("new"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tm.get("new"); got != topic {
+		t.Error("topic not registered under its new name")
+	}
+	if _, ok := tm.get("old"); ok {
+		t.Error("topic still registered under its old name")
+	}
+	want := Subscriptions{"new": topic}
+	if got := s.GetSubscriptions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("subscriber subscriptions = %v, want %v", got, want)
+	}
+	if got := p.GetSubscriptions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("publisher subscriptions = %v, want %v", got, want)
+	}
+
+	if err := s.Unsub(topic); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetSubscriptions(); len(got) != 0 {
+		t.Errorf("subscriptions after Unsub() = %v, want none", got)
+	}
+	if err := tm.DeleteTopic("new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.GetSubscriptions(); len(got) != 0 {
+		t.Errorf("publisher subscriptions after DeleteTopic() = %v, want none", got)
+	}
+}
+
 func TestTopic_SetTypeSafe(t1 *testing.T) {
 	type args struct {
 		typeSafe bool
 	}
 	tests := []struct {
 		name    string
-		topic   Topic
+		topic   *Topic
 		args    args
 		wantErr bool
 	}{
 		{name: "SetTypeSafe, AllowSetTypeSafe true",
-			topic: Topic{
+			topic: &Topic{
 				name: "SetTypeSafe, AllowSetTypeSafe true",
 				cfg: TopicConfig{
 					AllowSetTypeSafe: true,
 				},
 			}, args: args{typeSafe: true}, wantErr: false},
 		{name: "SetTypeSafe, AllowSetTypeSafe false",
-			topic: Topic{
+			topic: &Topic{
 				name: "SetTypeSafe, AllowSetTypeSafe false",
 				cfg: TopicConfig{
 					AllowSetTypeSafe: false,
@@ -693,7 +818,7 @@ func TestTopic_SetTypes(t1 *testing.T) {
 			tmpTypes := func(types ...interface{}) (t Types) {
 				t = make(Types, 0)
 				for _, v := range types {
-					t[reflect.TypeOf(v).Name()] = reflect.TypeOf(v)
+					t[TypeKey(reflect.TypeOf(v))] = reflect.TypeOf(v)
 				}
 				return
 			}
@@ -777,7 +902,7 @@ func TestTopic_Types(t1 *testing.T) {
 					Types: NewTypes(Topic{}),
 				},
 			}, wantTy: Types{
-				"Topic": reflect.TypeOf(Topic{}),
+				TypeKey(reflect.TypeOf(Topic{})): reflect.TypeOf(Topic{}),
 			},
 		},
 		{name: "Topic three Types",
@@ -787,9 +912,9 @@ func TestTopic_Types(t1 *testing.T) {
 					Types: NewTypes("", 41, Topic{}),
 				},
 			}, wantTy: Types{
-				"Topic":  reflect.TypeOf(Topic{}),
-				"int":    reflect.TypeOf(42),
-				"string": reflect.TypeOf(""),
+				TypeKey(reflect.TypeOf(Topic{})): reflect.TypeOf(Topic{}),
+				"int":                            reflect.TypeOf(42),
+				"string":                         reflect.TypeOf(""),
 			},
 		},
 	}
@@ -803,3 +928,134 @@ func TestTopic_Types(t1 *testing.T) {
 		})
 	}
 }
+
+func TestTopic_RemoveSub(t1 *testing.T) {
+	tests := []struct {
+		name       string
+		subscribed bool
+		wantErr    bool
+	}{
+		{name: "Remove subscribed Subscriber", subscribed: true, wantErr: false},
+		{name: "Remove not subscribed Subscriber", subscribed: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			t, _ := NewTopic(TopicName("TestTopic_RemoveSub "+tt.name), TopicConfig{})
+			s, _ := NewSubscriber("s", nil, nil)
+			var events []RemovalEvent
+			s.OnRemoval(func(ev RemovalEvent) {
+				events = append(events, ev)
+			})
+			if tt.subscribed {
+				_ = s.Sub(t)
+			}
+			err := t.RemoveSub(s)
+			if (err != nil) != tt.wantErr {
+				t1.Errorf("RemoveSub() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := t.Subscribers()["s"]; ok {
+				t1.Errorf("Subscribers() still contains removed Subscriber")
+			}
+			if _, ok := s.GetSubscriptions()[t.Name()]; ok {
+				t1.Errorf("GetSubscriptions() still contains Topic")
+			}
+			wantEvents := []RemovalEvent(nil)
+			if tt.subscribed {
+				wantEvents = []RemovalEvent{{Topic: t.Name(), Reason: Unsubscribed}}
+			}
+			if !reflect.DeepEqual(events, wantEvents) {
+				t1.Errorf("RemovalEvents = %v, want %v", events, wantEvents)
+			}
+		})
+	}
+}
+
+func TestTopic_RemovePub(t1 *testing.T) {
+	tests := []struct {
+		name        string
+		whitelisted bool
+		wantErr     bool
+	}{
+		{name: "Remove whitelisted Publisher", whitelisted: true, wantErr: false},
+		{name: "Remove not whitelisted Publisher", whitelisted: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			p := NewPublisher("p")
+			var pubs []*Publisher
+			if tt.whitelisted {
+				pubs = append(pubs, p)
+			}
+			t, _ := NewTopic(TopicName("TestTopic_RemovePub "+tt.name), TopicConfig{}, pubs...)
+			_ = p.AddSubscription(t)
+			var events []RemovalEvent
+			p.OnRemoval(func(ev RemovalEvent) {
+				events = append(events, ev)
+			})
+			err := t.RemovePub(p)
+			if (err != nil) != tt.wantErr {
+				t1.Errorf("RemovePub() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if _, ok := p.GetSubscriptions()[t.Name()]; ok {
+					t1.Errorf("GetSubscriptions() still contains Topic")
+				}
+				if !reflect.DeepEqual(events, []RemovalEvent{{Topic: t.Name(), Reason: PublisherRemoved}}) {
+					t1.Errorf("RemovalEvents = %v", events)
+				}
+				if err = t.Pub(p, "42"); err == nil {
+					t1.Errorf("Pub() by removed Publisher error = nil, want error")
+				}
+			}
+		})
+	}
+}
+
+type ctxKey string
+
+func TestTopic_PubCtx(t1 *testing.T) {
+	tests := []struct {
+		name      string
+		listening bool
+		wantErr   error
+	}{
+		{name: "Subscriber listening, context values reach handler", listening: true, wantErr: nil},
+		{name: "Subscriber not listening, publish cancelled", listening: false, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			t, _ := NewTopic(TopicName("TestTopic_PubCtx "+tt.name), TopicConfig{AllowAllPublishers: true})
+			received := make(chan context.Context, 1)
+			var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+				received <- ctx
+				return nil
+			}
+			s, _ := NewSubscriber("s", nil, []*Topic{t})
+			_ = s.AddHandlerCtx("any", &h)
+			if tt.listening {
+				s.Listen()
+			}
+
+			ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey("trace"), "42"), 50*time.Millisecond)
+			err := p1.PubCtx(ctx, t, "msg")
+			cancel()
+			if !errors.Is(err, tt.wantErr) {
+				t1.Fatalf("PubCtx() error = %v, want %v", err, tt.wantErr)
+			}
+			if !tt.listening {
+				return
+			}
+			select {
+			case got := <-received:
+				if got.Value(ctxKey("trace")) != "42" {
+					t1.Errorf("handler context value = %v, want 42", got.Value(ctxKey("trace")))
+				}
+				if got.Err() != nil {
+					t1.Errorf("handler context cancelled with publisher: %v", got.Err())
+				}
+			case <-time.After(time.Second):
+				t1.Fatal("handler not called")
+			}
+		})
+	}
+}
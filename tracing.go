@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+// Headers carrying the W3C trace context of a message.
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// Tracer creates the spans of publishing and handling messages and carries
+// the trace context from the publisher to the handlers in the Envelope
+// Headers. See the separate module github.com/georgegkinis/pubsub/otelpubsub
+// for an OpenTelemetry Tracer, so the core module does not depend on it.
+type Tracer interface {
+	// StartPublish starts the span of publishing env as a child of the span
+	// in ctx, and injects its trace context into env.Headers.
+	StartPublish(ctx context.Context, env *Envelope) (context.Context, Span)
+	// StartHandle starts the span of handling env as a child of the
+	// publishing span, using the trace context in env.Headers.
+	StartHandle(ctx context.Context, env *Envelope) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, recording err if it is not nil.
+	End(err error)
+}
+
+// TracePublish returns a PublishInterceptor starting a publishing span for
+// every message. The span ends once the message passed the interceptors after it.
+func TracePublish(tr Tracer) PublishInterceptor {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, env *Envelope) (err error) {
+			ctx, span := tr.StartPublish(ctx, env)
+			defer func() {
+				span.End(err)
+			}()
+			return next(ctx, env)
+		}
+	}
+}
+
+// TraceHandle returns a HandlerMiddleware starting a handling span for every
+// call of a handler. The handler gets the context carrying the span.
+func TraceHandle(tr Tracer) HandlerMiddleware {
+	return func(next HandlerCtxFunc) HandlerCtxFunc {
+		return func(ctx context.Context, msg interface{}) (err error) {
+			env, ok := EnvelopeFromContext(ctx)
+			if !ok {
+				return next(ctx, msg)
+			}
+			ctx, span := tr.StartHandle(ctx, env)
+			defer func() {
+				if v := recover(); v != nil {
+					span.End(fmt.Errorf("handler panicked: %v", v))
+					panic(v)
+				}
+				span.End(err)
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// UseTracer traces publishing to all Topics and handling by all Subscribers
+// of the TopicManager with tr.
+func (tm *TopicManager) UseTracer(tr Tracer) {
+	tm.UsePublish(TracePublish(tr))
+	tm.UseHandle(TraceHandle(tr))
+}
@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+// recordTracer propagates its span names in the traceparent header.
+type recordTracer struct {
+	mu    sync.Mutex
+	ended map[string]error
+}
+
+type recordSpan struct {
+	tr   *recordTracer
+	name string
+}
+
+func (s recordSpan) End(err error) {
+	s.tr.mu.Lock()
+	defer s.tr.mu.Unlock()
+	s.tr.ended[s.name] = err
+}
+
+func (tr *recordTracer) StartPublish(ctx context.Context, env *Envelope) (context.Context, Span) {
+	env.Headers[HeaderTraceparent] = "publish " + env.Payload.(string)
+	return context.WithValue(ctx, spanKey{}, "publish"), recordSpan{tr: tr, name: "publish " + env.Payload.(string)}
+}
+
+func (tr *recordTracer) StartHandle(ctx context.Context, env *Envelope) (context.Context, Span) {
+	name := "handle " + env.Headers[HeaderTraceparent]
+	return context.WithValue(ctx, spanKey{}, name), recordSpan{tr: tr, name: name}
+}
+
+func TestTopicManager_UseTracer(t *testing.T) {
+	defer resetMiddleware()
+	tr := &recordTracer{ended: make(map[string]error)}
+	TM.UseTracer(tr)
+
+	topic, _ := NewTopic("TestTopicManager_UseTracer", TopicConfig{AllowAllPublishers: true})
+	errFailed := errors.New("failed")
+	spans := make(chan interface{}, 2)
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		spans <- ctx.Value(spanKey{})
+		if msg == "fail" {
+			return errFailed
+		}
+		return nil
+	}
+	s, _ := NewSubscriber("TestTopicManager_UseTracer", nil, []*Topic{topic})
+	_ = s.AddHandlerCtx("any", &h)
+	s.Listen()
+	_ = topic.Pub(p1, "ok", "fail")
+	for _, want := range []string{"handle publish ok", "handle publish fail"} {
+		select {
+		case got := <-spans:
+			if got != want {
+				t.Errorf("handler context carries span %v, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("handler not called")
+		}
+	}
+	_ = s.Close()
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	want := map[string]error{"publish ok": nil, "publish fail": nil, "handle publish ok": nil, "handle publish fail": errFailed}
+	if len(tr.ended) != len(want) {
+		t.Fatalf("ended spans %v, want %v", tr.ended, want)
+	}
+	for k, v := range want {
+		if err, ok := tr.ended[k]; !ok || err != v {
+			t.Errorf("span %s ended with %v, want %v", k, err, v)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// TypedTopic is a Topic that only carries messages of type T.
+// The underlying Topic is type safe for T, so untyped publishers
+// and subscribers can still use it through the embedded *Topic.
+type TypedTopic[T any] struct {
+	*Topic
+}
+
+// NewTypedTopic creates a Topic carrying messages of type T and registers it
+// in the default TopicManager TM. Any Types in cfg are replaced by T. When T
+// is an interface type the Topic is not type safe at runtime, as any
+// implementation of T may be published.
+func NewTypedTopic[T any](name TopicName, cfg TopicConfig, pubs ...*Publisher) (*TypedTopic[T], error) {
+	return NewTypedTopicIn[T](TM, name, cfg, pubs...)
+}
+
+// NewTypedTopicIn creates a Topic carrying messages of type T and registers
+// it in tm, see NewTypedTopic.
+func NewTypedTopicIn[T any](tm *TopicManager, name TopicName, cfg TopicConfig, pubs ...*Publisher) (*TypedTopic[T], error) {
+	cfg.Types = nil
+	cfg.TypeSafe = false
+	if rt := typeOf[T](); rt.Kind() != reflect.Interface {
+		cfg.Types = Types{TypeKey(rt): rt}
+	}
+	t, err := tm.NewTopic(name, cfg, pubs...)
+	if t == nil {
+		return nil, err
+	}
+	return &TypedTopic[T]{Topic: t}, err
+}
+
+// TypedPublisher publishes messages of type T to TypedTopics of the same type.
+type TypedPublisher[T any] struct {
+	*Publisher
+}
+
+// NewTypedPublisher creates a TypedPublisher subscribed to topics. It belongs
+// to the TopicManager of the first topic, or to TM without topics.
+func NewTypedPublisher[T any](name string, topics ...*TypedTopic[T]) *TypedPublisher[T] {
+	tm := TM
+	if len(topics) > 0 {
+		tm = topics[0].manager()
+	}
+	p := &TypedPublisher[T]{Publisher: tm.NewPublisher(name)}
+	for _, v := range topics {
+		_ = p.AddSubscription(v.Topic)
+	}
+	return p
+}
+
+// Publish publishes msg to all Topics the publisher is subscribed to.
+func (p *TypedPublisher[T]) Publish(ctx context.Context, msg T) (err error) {
+	return p.PubAllCtx(ctx, msg)
+}
+
+// PublishTo publishes msg to topic.
+func (p *TypedPublisher[T]) PublishTo(ctx context.Context, topic *TypedTopic[T], msg T) (err error) {
+	return p.PubCtx(ctx, topic.Topic, msg)
+}
+
+// TypedSubscriber receives messages of type T.
+type TypedSubscriber[T any] struct {
+	*Subscriber
+}
+
+// NewTypedSubscriber creates a TypedSubscriber subscribed to topics. It belongs
+// to the TopicManager of the first topic, or to TM without topics.
+func NewTypedSubscriber[T any](name string, topics ...*TypedTopic[T]) (*TypedSubscriber[T], error) {
+	tm := TM
+	subscriptions := make([]*Topic, 0, len(topics))
+	for i, v := range topics {
+		if i == 0 {
+			tm = v.manager()
+		}
+		subscriptions = append(subscriptions, v.Topic)
+	}
+	s, err := tm.NewSubscriber(name, nil, subscriptions)
+	if s == nil {
+		return nil, err
+	}
+	return &TypedSubscriber[T]{Subscriber: s}, err
+}
+
+// Subscribe registers handler for messages of type T.
+// When T is an interface type the handler receives every message
+// implementing T that has no more specific handler.
+func (s *TypedSubscriber[T]) Subscribe(handler func(T) error) (err error) {
+	if handler == nil {
+		err = fmt.Errorf("Required: handler for Subscriber %s", s.Name())
+		return
+	}
+	return s.SubscribeCtx(func(_ context.Context, msg T) error {
+		return handler(msg)
+	})
+}
+
+// SubscribeCtx registers handler for messages of type T, passing on the
+// context the message was published with. See Subscribe.
+func (s *TypedSubscriber[T]) SubscribeCtx(handler func(context.Context, T) error) (err error) {
+	if handler == nil {
+		err = fmt.Errorf("Required: handler for Subscriber %s", s.Name())
+		return
+	}
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		m, ok := msg.(T)
+		if !ok {
+			return fmt.Errorf("subscriber %s expected message of type %v, got %T", s.Name(), typeOf[T](), msg)
+		}
+		return handler(ctx, m)
+	}
+	return s.AddHandlerCtx(typeOf[T](), &h)
+}
+
+// typeOf returns the reflect.Type of T, also when T is an interface type.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type order struct {
+	ID int
+}
+
+func TestTypedTopic_PublishSubscribe(t *testing.T) {
+	topic, err := NewTypedTopic[order]("TestTypedTopic orders", TopicConfig{AllowAllPublishers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !topic.IsTypeSafe() {
+		t.Errorf("IsTypeSafe() = false, want true")
+	}
+
+	received := make(chan order, 1)
+	s, err := NewTypedSubscriber[order]("TestTypedTopic typed", topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Subscribe(func(o order) error {
+		received <- o
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+
+	anyReceived := make(chan interface{}, 1)
+	var h HandlerFunc = func(msg interface{}) error {
+		anyReceived <- msg
+		return nil
+	}
+	untyped, _ := NewSubscriber("TestTypedTopic untyped", Handlers{"any": &h}, []*Topic{topic.Topic})
+	untyped.Listen()
+
+	p := NewTypedPublisher[order]("TestTypedTopic publisher", topic)
+	if err = p.Publish(context.Background(), order{ID: 42}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case o := <-received:
+		if o.ID != 42 {
+			t.Errorf("typed handler received %v, want ID 42", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("typed handler did not receive message")
+	}
+	select {
+	case msg := <-anyReceived:
+		if msg.(order).ID != 42 {
+			t.Errorf("untyped handler received %v, want ID 42", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("untyped handler did not receive message")
+	}
+
+	if err = topic.Pub(p1, "not an order"); err == nil {
+		t.Errorf("untyped Pub() of string on TypedTopic[order] error = nil, want error")
+	}
+}
+
+func TestTypedPublisher_PublishCancelled(t *testing.T) {
+	topic, _ := NewTypedTopic[int]("TestTypedPublisher cancelled", TopicConfig{AllowAllPublishers: true})
+	p := NewTypedPublisher[int]("TestTypedPublisher cancelled", topic)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.PublishTo(ctx, topic, 42); err == nil {
+		t.Errorf("PublishTo() with cancelled context error = nil, want error")
+	}
+}
+
+func TestTypedSubscriber_SubscribeInterface(t *testing.T) {
+	topic, _ := NewTypedTopic[fmt.Stringer]("TestTypedSubscriber interface", TopicConfig{AllowAllPublishers: true})
+	if topic.IsTypeSafe() {
+		t.Errorf("IsTypeSafe() = true, want false for interface type")
+	}
+	received := make(chan string, 1)
+	s, _ := NewTypedSubscriber[fmt.Stringer]("TestTypedSubscriber interface", topic)
+	_ = s.Subscribe(func(msg fmt.Stringer) error {
+		received <- msg.String()
+		return nil
+	})
+	s.Listen()
+
+	p := NewTypedPublisher[fmt.Stringer]("TestTypedSubscriber interface", topic)
+	if err := p.Publish(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "1s" {
+			t.Errorf("handler received %s, want 1s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler did not receive message")
+	}
+}
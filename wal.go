@@ -0,0 +1,394 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walRecord is a message as stored in the write-ahead log.
+type walRecord struct {
+	Offset    uint64
+	ID        string
+	Time      time.Time
+	Publisher string
+	Key       string
+	Headers   map[string]string
+	Payload   interface{}
+}
+
+// walSegment is a log file holding the records from base on.
+type walSegment struct {
+	base uint64
+	path string
+	size int64
+}
+
+// wal is a write-ahead log split into segment files named after the offset
+// of their first record. Every record is framed by its length and CRC-32, so
+// a record torn by a crash is detected and cut off when the log is opened.
+type wal struct {
+	mu       sync.Mutex
+	cfg      DurableConfig
+	segments []*walSegment
+	active   *os.File
+	next     uint64
+	dirty    bool
+	lock     *dirLock
+	stop     chan struct{}
+	stopped  chan struct{}
+	closed   bool
+}
+
+const walHeaderSize = 8
+
+func segmentName(base uint64) string {
+	return fmt.Sprintf("%020d.log", base)
+}
+
+// openWAL opens the log in cfg.Dir, creating it if needed. The directory is
+// locked until the log is closed.
+func openWAL(cfg DurableConfig) (w *wal, err error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("Required: Dir of durable topic")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if err = os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	w = &wal{cfg: cfg, next: 1, lock: lock}
+	opened := w
+	defer func() {
+		if err != nil {
+			if opened.active != nil {
+				opened.active.Close()
+			}
+			lock.release()
+		}
+	}()
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		w.segments = append(w.segments, &walSegment{base: base, path: filepath.Join(cfg.Dir, name), size: info.Size()})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].base < w.segments[j].base })
+
+	if len(w.segments) == 0 {
+		if err = w.rotate(); err != nil {
+			return nil, err
+		}
+	} else if err = w.recover(); err != nil {
+		return nil, err
+	}
+	w.applyRetention()
+
+	if cfg.Fsync == FsyncInterval {
+		w.stop, w.stopped = make(chan struct{}), make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// recover finds the next offset from the last segment, cuts off a torn
+// record at its end and opens it for appending.
+func (w *wal) recover() (err error) {
+	last := w.segments[len(w.segments)-1]
+	w.next = last.base
+	valid, err := scanSegment(last.path, func(r walRecord) error {
+		w.next = r.Offset + 1
+		return nil
+	})
+	if err != nil && !errors.Is(err, errTornRecord) {
+		return err
+	}
+	if w.active, err = os.OpenFile(last.path, os.O_RDWR, 0o644); err != nil {
+		return err
+	}
+	if valid < last.size {
+		if err = w.active.Truncate(valid); err != nil {
+			return err
+		}
+		last.size = valid
+	}
+	_, err = w.active.Seek(valid, io.SeekStart)
+	return
+}
+
+var errTornRecord = errors.New("torn record")
+
+// scanSegment calls fn for every record of the segment file at path.
+// It returns the size of the valid records, and errTornRecord if the file
+// ends with an incomplete or corrupt record.
+func scanSegment(path string, fn func(walRecord) error) (valid int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return valid, nil
+			}
+			return valid, errTornRecord
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:4]))
+		if _, err = io.ReadFull(r, data); err != nil || crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return valid, errTornRecord
+		}
+		var rec walRecord
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+			return valid, fmt.Errorf("decoding record in %s: %w", path, err)
+		}
+		if err = fn(rec); err != nil {
+			return valid, err
+		}
+		valid += int64(walHeaderSize + len(data))
+	}
+}
+
+// append writes records to the log, assigning their offsets. Either all
+// records are written, to the same segment, or none.
+func (w *wal) append(records []*walRecord) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("log in %s: %w", w.cfg.Dir, ErrClosed)
+	}
+	// The whole batch is encoded first, so it is either written or not.
+	var buf bytes.Buffer
+	for i, rec := range records {
+		rec.Offset = w.next + uint64(i)
+		start := buf.Len()
+		buf.Write(make([]byte, walHeaderSize))
+		if err = gob.NewEncoder(&buf).Encode(rec); err != nil {
+			return fmt.Errorf("encoding message of type %T, register it with gob.Register: %w", rec.Payload, err)
+		}
+		b := buf.Bytes()[start:]
+		binary.BigEndian.PutUint32(b[:4], uint32(len(b)-walHeaderSize))
+		binary.BigEndian.PutUint32(b[4:walHeaderSize], crc32.ChecksumIEEE(b[walHeaderSize:]))
+	}
+
+	last := w.segments[len(w.segments)-1]
+	if last.size > 0 && last.size+int64(buf.Len()) > w.cfg.SegmentSize {
+		if err = w.rotate(); err != nil {
+			return
+		}
+		w.applyRetention()
+		last = w.segments[len(w.segments)-1]
+	}
+	if _, err = w.active.Write(buf.Bytes()); err != nil {
+		// Cut off what was written of the batch.
+		if tErr := w.active.Truncate(last.size); tErr == nil {
+			_, _ = w.active.Seek(last.size, io.SeekStart)
+		}
+		return
+	}
+	last.size += int64(buf.Len())
+	w.next += uint64(len(records))
+	w.dirty = true
+	if w.cfg.Fsync == FsyncAlways {
+		err = w.sync()
+	}
+	return
+}
+
+// rotate closes the active segment and starts a new one at w.next.
+func (w *wal) rotate() (err error) {
+	if w.active != nil {
+		if err = w.active.Sync(); err != nil {
+			return
+		}
+		if err = w.active.Close(); err != nil {
+			return
+		}
+	}
+	seg := &walSegment{base: w.next, path: filepath.Join(w.cfg.Dir, segmentName(w.next))}
+	if w.active, err = os.OpenFile(seg.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644); err != nil {
+		return
+	}
+	w.segments = append(w.segments, seg)
+	return
+}
+
+// applyRetention removes the oldest segments, never the active one, while the
+// log is larger than RetentionSize or the segment is older than RetentionAge.
+func (w *wal) applyRetention() {
+	var total int64
+	for _, s := range w.segments {
+		total += s.size
+	}
+	for len(w.segments) > 1 {
+		oldest := w.segments[0]
+		expired := false
+		if w.cfg.RetentionAge > 0 {
+			if info, err := os.Stat(oldest.path); err == nil && time.Since(info.ModTime()) > w.cfg.RetentionAge {
+				expired = true
+			}
+		}
+		if !expired && (w.cfg.RetentionSize <= 0 || total <= w.cfg.RetentionSize) {
+			return
+		}
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		total -= oldest.size
+		w.segments = w.segments[1:]
+	}
+}
+
+func (w *wal) sync() (err error) {
+	if !w.dirty {
+		return
+	}
+	if err = w.active.Sync(); err == nil {
+		w.dirty = false
+	}
+	return
+}
+
+func (w *wal) syncLoop() {
+	defer close(w.stopped)
+	interval := w.cfg.FsyncInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			_ = w.sync()
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// end returns the offset the next record will get.
+func (w *wal) end() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.next
+}
+
+// first returns the offset of the oldest retained record.
+func (w *wal) first() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.segments[0].base
+}
+
+// offsetAt returns the offset of the first record before to with a Time not
+// before t, or to if there is none.
+func (w *wal) offsetAt(t time.Time, to uint64) (offset uint64, err error) {
+	offset = to
+	errFound := errors.New("found")
+	err = w.read(w.first(), to, func(r walRecord) error {
+		if !r.Time.Before(t) {
+			offset = r.Offset
+			return errFound
+		}
+		return nil
+	})
+	if errors.Is(err, errFound) {
+		err = nil
+	}
+	return
+}
+
+// read calls fn for the records from offset from up to, not including, to.
+// Records removed by retention are skipped.
+func (w *wal) read(from, to uint64, fn func(walRecord) error) (err error) {
+	w.mu.Lock()
+	segments := make([]walSegment, 0, len(w.segments))
+	for i, s := range w.segments {
+		if s.base >= to {
+			break
+		}
+		if i+1 < len(w.segments) && w.segments[i+1].base <= from {
+			continue
+		}
+		segments = append(segments, *s)
+	}
+	w.mu.Unlock()
+
+	errDone := errors.New("done")
+	for _, s := range segments {
+		_, err = scanSegment(s.path, func(r walRecord) error {
+			if r.Offset >= to {
+				return errDone
+			}
+			if r.Offset < from {
+				return nil
+			}
+			return fn(r)
+		})
+		if errors.Is(err, errDone) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, errTornRecord) {
+			return
+		}
+	}
+	return nil
+}
+
+// close syncs and closes the log.
+func (w *wal) close() (err error) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	if err = w.active.Sync(); err == nil {
+		err = w.active.Close()
+	}
+	if lErr := w.lock.release(); err == nil {
+		err = lErr
+	}
+	stop, stopped := w.stop, w.stopped
+	w.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+	return
+}
@@ -0,0 +1,556 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type walMsg struct{ N int }
+
+func init() {
+	gob.Register(walMsg{})
+}
+
+func readAll(t *testing.T, w *wal, from uint64) (got []interface{}) {
+	t.Helper()
+	if err := w.read(from, w.end(), func(r walRecord) error {
+		got = append(got, r.Payload)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestWAL(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          DurableConfig
+		msgs         []interface{}
+		from         uint64
+		want         []interface{}
+		wantSegments int
+	}{
+		{name: "Single segment", cfg: DurableConfig{},
+			msgs: []interface{}{1, "two", walMsg{3}}, from: 1, want: []interface{}{1, "two", walMsg{3}}, wantSegments: 1},
+		{name: "Read from offset", cfg: DurableConfig{},
+			msgs: []interface{}{1, 2, 3}, from: 3, want: []interface{}{3}, wantSegments: 1},
+		{name: "Rotation", cfg: DurableConfig{SegmentSize: 1, Fsync: FsyncNever},
+			msgs: []interface{}{1, 2, 3}, from: 1, want: []interface{}{1, 2, 3}, wantSegments: 3},
+		{name: "Retention by size", cfg: DurableConfig{SegmentSize: 1, RetentionSize: 1},
+			msgs: []interface{}{1, 2, 3}, from: 1, want: []interface{}{3}, wantSegments: 1},
+		{name: "Fsync interval", cfg: DurableConfig{Fsync: FsyncInterval, FsyncInterval: time.Millisecond},
+			msgs: []interface{}{1, 2}, from: 2, want: []interface{}{2}, wantSegments: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Dir = t.TempDir()
+			w, err := openWAL(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.close()
+			for _, m := range tt.msgs {
+				if err = w.append([]*walRecord{{Payload: m}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := readAll(t, w, tt.from); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read() = %v, want %v", got, tt.want)
+			}
+			files, _ := filepath.Glob(filepath.Join(tt.cfg.Dir, "*.log"))
+			if len(files) != tt.wantSegments {
+				t.Errorf("%d segments, want %d", len(files), tt.wantSegments)
+			}
+		})
+	}
+}
+
+func TestWAL_AppendBatch(t *testing.T) {
+	type unregistered struct{ N int }
+	w, err := openWAL(DurableConfig{Dir: t.TempDir(), SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if err = w.append([]*walRecord{{Payload: 1}, {Payload: unregistered{2}}}); err == nil {
+		t.Fatal("append() of an unregistered type succeeded")
+	}
+	if got := readAll(t, w, 1); got != nil {
+		t.Errorf("read() after failed append = %v, want nothing", got)
+	}
+	records := []*walRecord{{Payload: 3}, {Payload: 4}}
+	if err = w.append(records); err != nil {
+		t.Fatal(err)
+	}
+	if records[0].Offset != 1 || records[1].Offset != 2 {
+		t.Errorf("offsets %d, %d, want 1, 2", records[0].Offset, records[1].Offset)
+	}
+	if got, want := readAll(t, w, 1), []interface{}{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("read() = %v, want %v", got, want)
+	}
+	if files, _ := filepath.Glob(filepath.Join(w.cfg.Dir, "*.log")); len(files) != 1 {
+		t.Errorf("batch written to %d segments, want 1", len(files))
+	}
+}
+
+func TestWAL_Reopen(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir(), SegmentSize: 100}
+	w, err := openWAL(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.append([]*walRecord{{Payload: 1}, {Payload: 2}, {Payload: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.append([]*walRecord{{Payload: 4}}); err == nil {
+		t.Error("append() to closed log succeeded")
+	}
+
+	// Simulate a record torn by a crash.
+	files, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.log"))
+	f, err := os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	if w, err = openWAL(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if err = w.append([]*walRecord{{Payload: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, w, 1), []interface{}{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("read() = %v, want %v", got, want)
+	}
+}
+
+func TestTopic_Durable(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		mu  sync.Mutex
+		got []interface{}
+	)
+	var h HandlerFunc = func(msg interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, msg)
+		return nil
+	}
+	handled := func(n int) {
+		t.Helper()
+		deadline := time.After(time.Second)
+		for {
+			mu.Lock()
+			l := len(got)
+			mu.Unlock()
+			if l >= n {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("%d messages handled, want %d", l, n)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	start := func() (*TopicManager, *Topic, *Publisher) {
+		tm := NewTopicManager()
+		topic, err := tm.NewTopic("TestTopic_Durable", TopicConfig{AllowAllPublishers: true, Durable: &DurableConfig{Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm, topic, tm.NewPublisher("p")
+	}
+
+	tm, topic, p := start()
+	s, err := tm.NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, []*Topic{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	if err = topic.Pub(p, 1, walMsg{2}); err != nil {
+		t.Fatal(err)
+	}
+	handled(2)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Not handled before the restart.
+	if err = topic.Pub(p, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tm.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tm, topic, p = start()
+	defer tm.Shutdown(context.Background())
+	mu.Lock()
+	got = nil
+	mu.Unlock()
+	s, err = tm.NewSubscriberWithConfig("s", SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, []*Topic{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	handled(2)
+	if err = topic.Pub(p, 5); err != nil {
+		t.Fatal(err)
+	}
+	handled(3)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []interface{}{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
+
+func TestTopic_AddSubFrom(t *testing.T) {
+	dir := t.TempDir()
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, err := tm.NewTopic("TestTopic_AddSubFrom", TopicConfig{AllowAllPublishers: true, Durable: &DurableConfig{Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := tm.NewPublisher("p")
+	_ = topic.Pub(p, 1, 2)
+	time.Sleep(time.Millisecond)
+	third := time.Now()
+	_ = topic.Pub(p, 3, 4)
+
+	tests := []struct {
+		name string
+		pos  StartPosition
+		want []interface{}
+	}{
+		{name: "Resume without offset", pos: Resume, want: []interface{}{5}},
+		{name: "New messages", pos: NewMessages, want: []interface{}{5}},
+		{name: "Beginning", pos: Beginning, want: []interface{}{1, 2, 3, 4, 5}},
+		{name: "Offset", pos: AtOffset(3), want: []interface{}{3, 4, 5}},
+		{name: "Offset past the end", pos: AtOffset(100), want: []interface{}{5}},
+		{name: "Time", pos: AtTime(third), want: []interface{}{3, 4, 5}},
+	}
+	subs := make([]*Subscriber, len(tests))
+	got := make([]chan interface{}, len(tests))
+	for i, tt := range tests {
+		ch := make(chan interface{}, 10)
+		var h HandlerFunc = func(msg interface{}) error {
+			ch <- msg
+			return nil
+		}
+		got[i] = ch
+		subs[i], _ = tm.NewSubscriberWithConfig(tt.name, SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, nil)
+		if err = subs[i].SubFrom(topic, tt.pos); err != nil {
+			t.Fatal(err)
+		}
+		subs[i].Listen()
+	}
+	_ = topic.Pub(p, 5)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs []interface{}
+			for len(msgs) < len(tt.want) {
+				select {
+				case m := <-got[i]:
+					msgs = append(msgs, m)
+				case <-time.After(time.Second):
+					t.Fatalf("handled %v, want %v", msgs, tt.want)
+				}
+			}
+			if !reflect.DeepEqual(msgs, tt.want) {
+				t.Errorf("handled %v, want %v", msgs, tt.want)
+			}
+			deadline := time.After(time.Second)
+			for {
+				if off, _ := topic.Offset(tt.name); off == 6 {
+					break
+				}
+				select {
+				case <-deadline:
+					off, _ := topic.Offset(tt.name)
+					t.Fatalf("Offset() = %d, want 6", off)
+				case <-time.After(time.Millisecond):
+				}
+			}
+		})
+	}
+
+	plain, _ := tm.NewTopic("TestTopic_AddSubFrom plain", TopicConfig{})
+	if err = subs[0].SubFrom(plain, Beginning); err == nil {
+		t.Error("SubFrom() a topic that is not durable succeeded")
+	}
+}
+
+func TestTopic_DurableGroup(t *testing.T) {
+	dir := t.TempDir()
+	start := func() (*TopicManager, *Topic) {
+		tm := NewTopicManager()
+		topic, err := tm.NewTopic("TestTopic_DurableGroup", TopicConfig{AllowAllPublishers: true, Durable: &DurableConfig{Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm, topic
+	}
+
+	tm, topic := start()
+	if _, err := tm.NewSubscriberWithConfig("a", SubscriberConfig{QueueSize: 10, Group: "g"}, nil, []*Topic{topic}); err != nil {
+		t.Fatal(err)
+	}
+	_ = topic.Pub(tm.NewPublisher("p"), 1, 2)
+	// a never handles them.
+	if _, err := tm.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tm, topic = start()
+	defer tm.Shutdown(context.Background())
+	got := make(chan interface{}, 10)
+	var h HandlerFunc = func(msg interface{}) error {
+		got <- msg
+		return nil
+	}
+	b, err := tm.NewSubscriberWithConfig("b", SubscriberConfig{QueueSize: 10, Group: "g"}, Handlers{"any": &h}, []*Topic{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Listen()
+	var msgs []interface{}
+	for len(msgs) < 2 {
+		select {
+		case m := <-got:
+			msgs = append(msgs, m)
+		case <-time.After(time.Second):
+			t.Fatalf("handled %v, want [1 2]", msgs)
+		}
+	}
+	if want := []interface{}{1, 2}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("handled %v, want %v", msgs, want)
+	}
+}
+
+func TestWAL_Lock(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir()}
+	w, err := openWAL(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = openWAL(cfg); err == nil {
+		t.Error("openWAL() of a directory in use succeeded")
+	}
+	tm := NewTopicManager()
+	if _, err = tm.NewTopic("topic", TopicConfig{Durable: &cfg}); err == nil {
+		t.Error("NewTopic() with a log directory in use succeeded")
+	}
+	if err = w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = openWAL(cfg); err != nil {
+		t.Fatalf("openWAL() after close: %v", err)
+	}
+	w.close()
+}
+
+func TestTopicManager_GetOrCreateDurable(t *testing.T) {
+	dir := t.TempDir()
+	start := func() *TopicManager {
+		tm, err := NewTopicManagerWithConfig(TopicsManagerConfig{
+			AutoCreate: true,
+			Templates:  []TopicTemplate{{Pattern: "orders.*", Config: TopicConfig{AllowAllPublishers: true, Durable: &DurableConfig{Dir: dir}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tm := start()
+	p := tm.NewPublisher("p")
+	for _, n := range []TopicName{"orders.a", "orders.b"} {
+		topic, err := tm.GetOrCreate(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tm.NewSubscriberWithConfig(string(n), SubscriberConfig{QueueSize: 10}, nil, []*Topic{topic}); err != nil {
+			t.Fatal(err)
+		}
+		_ = topic.Pub(p, string(n))
+	}
+	if _, err := tm.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tm = start()
+	defer tm.Shutdown(context.Background())
+	for _, n := range []TopicName{"orders.a", "orders.b"} {
+		got := make(chan interface{}, 10)
+		var h HandlerFunc = func(msg interface{}) error {
+			got <- msg
+			return nil
+		}
+		s, err := tm.NewSubscriberWithConfig(string(n), SubscriberConfig{QueueSize: 10}, Handlers{"any": &h}, []*Topic{tm.Topic(n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Listen()
+		select {
+		case m := <-got:
+			if m != string(n) {
+				t.Errorf("%s replayed %v, want %s", n, m, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s replayed nothing", n)
+		}
+		select {
+		case m := <-got:
+			t.Errorf("%s replayed %v too", n, m)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestTopic_DurableOffsetsError(t *testing.T) {
+	dir := t.TempDir()
+	// The offsets cannot be written through a directory in the way.
+	if err := os.Mkdir(filepath.Join(dir, offsetsFile+".tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordLogger{}
+	tm := NewTopicManager()
+	topic, err := tm.NewTopic("TestTopic_DurableOffsetsError", TopicConfig{
+		Logger:  logger,
+		Durable: &DurableConfig{Dir: dir, CommitInterval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tm.NewSubscriber("s", nil, []*Topic{topic}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(time.Second)
+	for len(logger.Records()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("failure to save offsets was not logged")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if got := logger.Records()[0]["msg"]; got != "cannot save offsets" {
+		t.Errorf("logged %q, want %q", got, "cannot save offsets")
+	}
+	if err = topic.journal.close(); err == nil {
+		t.Error("close() with unsaved offsets error = nil")
+	}
+}
+
+func TestTopic_DurableGroupReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave unsubscribes the replaying member before it caught up.
+		leave bool
+	}{
+		{name: "Replaying member catches up", leave: false},
+		{name: "Replaying member leaves", leave: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTopicManager()
+			defer tm.Shutdown(context.Background())
+			topic, err := tm.NewTopic("topic", TopicConfig{AllowAllPublishers: true, Durable: &DurableConfig{Dir: t.TempDir()}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := tm.NewPublisher("p")
+			_ = topic.Pub(p, 1, 2, 3)
+
+			got := make(chan interface{}, 20)
+			var h HandlerFunc = func(msg interface{}) error {
+				got <- msg
+				return nil
+			}
+			// a replays the log, but its queue only holds one message until it listens.
+			a, err := tm.NewSubscriberWithConfig("a", SubscriberConfig{QueueSize: 1, Group: "g", Start: Beginning}, Handlers{"any": &h}, []*Topic{topic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for a.QueueLen() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			b, err := tm.NewSubscriberWithConfig("b", SubscriberConfig{QueueSize: 10, Group: "g"}, Handlers{"any": &h}, []*Topic{topic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Listen()
+			_ = topic.Pub(p, 4, 5, 6)
+			if tt.leave {
+				if err = a.Unsub(topic); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a.Listen()
+
+			seen := make(map[interface{}]int)
+			for i := 0; i < 6; i++ {
+				select {
+				case m := <-got:
+					seen[m]++
+				case <-time.After(time.Second):
+					t.Fatalf("handled %v, want 1 to 6 once", seen)
+				}
+			}
+			select {
+			case m := <-got:
+				seen[m]++
+			case <-time.After(50 * time.Millisecond):
+			}
+			if want := map[interface{}]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1}; !reflect.DeepEqual(seen, want) {
+				t.Errorf("handled %v, want %v", seen, want)
+			}
+		})
+	}
+}
+
+func TestTopic_DurableAppendOrder(t *testing.T) {
+	tm := NewTopicManager()
+	defer tm.Shutdown(context.Background())
+	topic, err := tm.NewTopic("TestTopic_DurableAppendOrder", TopicConfig{Durable: &DurableConfig{Dir: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := []*Envelope{{Payload: 1}}, []*Envelope{{Payload: 2}}
+	done := make(chan error, 1)
+	// A message must be tracked before a later one can be delivered and
+	// acknowledged, or the commit would skip it.
+	err = topic.journal.append(first, func() {
+		go func() { done <- topic.journal.append(second, func() {}) }()
+		select {
+		case err := <-done:
+			t.Error("appended before the previous batch was tracked")
+			done <- err
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if first[0].Offset != 1 || second[0].Offset != 2 {
+		t.Errorf("offsets %d, %d, want 1, 2", first[0].Offset, second[0].Offset)
+	}
+}
@@ -0,0 +1,75 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// workerPool handles the messages of a Subscriber in SubscriberConfig.Workers
+// goroutines. Without KeyOrdered all workers take messages from one queue.
+// With KeyOrdered every worker has its own queue and messages are assigned by
+// the hash of their Envelope.Key, so messages with the same key are handled
+// in order. Messages without a key are assigned round-robin.
+type workerPool struct {
+	s      *Subscriber
+	queues []chan interface{}
+	next   int
+	wg     sync.WaitGroup
+}
+
+func (s *Subscriber) startWorkers() *workerPool {
+	p := &workerPool{s: s}
+	shared := make(chan interface{})
+	for i := 0; i < s.cfg.Workers; i++ {
+		q := shared
+		if s.cfg.KeyOrdered {
+			q = make(chan interface{})
+			p.queues = append(p.queues, q)
+		}
+		p.wg.Add(1)
+		go p.work(q)
+	}
+	if !s.cfg.KeyOrdered {
+		p.queues = []chan interface{}{shared}
+	}
+	return p
+}
+
+// work handles the messages of q until it is closed. After a handler panic
+// with the RestartOnPanic policy the worker is replaced with a new one.
+func (p *workerPool) work(q chan interface{}) {
+	for msg := range q {
+		if p.s.handle(msg) && p.s.cfg.PanicPolicy == RestartOnPanic {
+			p.s.log().Warn("restarting worker after a handler panic", "subscriber", p.s.name)
+			go p.work(q)
+			return
+		}
+	}
+	p.wg.Done()
+}
+
+// dispatch passes msg to a worker, waiting until one takes it.
+// It never asks the listener to restart, as workers restart themselves.
+func (p *workerPool) dispatch(msg interface{}) (restart bool) {
+	q := p.queues[0]
+	if len(p.queues) > 1 {
+		if env, ok := msg.(*Envelope); ok && env.Key != "" {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(env.Key))
+			q = p.queues[h.Sum32()%uint32(len(p.queues))]
+		} else {
+			q = p.queues[p.next%len(p.queues)]
+			p.next++
+		}
+	}
+	q <- msg
+	return false
+}
+
+// stop waits for the workers to finish the messages they are handling.
+func (p *workerPool) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscriber_Workers(t *testing.T) {
+	const workers = 4
+	topic, _ := NewTopic("TestSubscriber_Workers", TopicConfig{AllowAllPublishers: true})
+	var running, maxRunning atomic.Int32
+	release := make(chan struct{})
+	var h HandlerFunc = func(msg interface{}) error {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		return nil
+	}
+	s, err := NewSubscriberWithConfig("TestSubscriber_Workers", SubscriberConfig{QueueSize: 10, Workers: workers}, Handlers{"any": &h}, []*Topic{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Listen()
+	if err = topic.Pub(p1, 1, 2, 3, 4, 5, 6); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(time.Second)
+	for running.Load() < workers {
+		select {
+		case <-deadline:
+			t.Fatalf("%d handlers running, want %d", running.Load(), workers)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	close(release)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := maxRunning.Load(); got != workers {
+		t.Errorf("at most %d handlers ran concurrently, want %d", got, workers)
+	}
+	if got := running.Load(); got != 0 {
+		t.Errorf("Close() returned with %d handlers running", got)
+	}
+}
+
+func TestSubscriber_WorkersKeyOrdered(t *testing.T) {
+	const (
+		keys     = 8
+		messages = 50
+	)
+	topic, _ := NewTopic("TestSubscriber_WorkersKeyOrdered", TopicConfig{AllowAllPublishers: true})
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	var h HandlerCtxFunc = func(ctx context.Context, msg interface{}) error {
+		env, _ := EnvelopeFromContext(ctx)
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		got[env.Key] = append(got[env.Key], msg.(int))
+		return nil
+	}
+	cfg := SubscriberConfig{QueueSize: 16, Workers: 4, KeyOrdered: true}
+	s, _ := NewSubscriberWithConfig("TestSubscriber_WorkersKeyOrdered", cfg, nil, []*Topic{topic})
+	_ = s.AddHandlerCtx(0, &h)
+	s.Listen()
+	for i := 0; i < messages; i++ {
+		for k := 0; k < keys; k++ {
+			if err := topic.PubCtx(WithKey(context.Background(), fmt.Sprint(k)), p1, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != keys {
+		t.Fatalf("got messages for %d keys, want %d", len(got), keys)
+	}
+	for k, v := range got {
+		if len(v) != messages {
+			t.Errorf("key %s got %d messages, want %d", k, len(v), messages)
+		}
+		for i := range v {
+			if v[i] != i {
+				t.Errorf("key %s handled out of order: %v", k, v)
+				break
+			}
+		}
+	}
+}
+
+func TestNewSubscriberWithConfig_NegativeWorkers(t *testing.T) {
+	if _, err := NewSubscriberWithConfig("TestNewSubscriberWithConfig_NegativeWorkers", SubscriberConfig{Workers: -1}, nil, nil); err == nil {
+		t.Errorf("NewSubscriberWithConfig() with negative Workers error = nil")
+	}
+}